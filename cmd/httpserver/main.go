@@ -1,125 +1,205 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"embed"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/aringq10/http-go-server/internal/compress"
+	"github.com/aringq10/http-go-server/internal/fileserver"
+	"github.com/aringq10/http-go-server/internal/headers"
 	"github.com/aringq10/http-go-server/internal/request"
 	"github.com/aringq10/http-go-server/internal/response"
 	"github.com/aringq10/http-go-server/internal/server"
+	"github.com/aringq10/http-go-server/internal/view"
 )
 
 const port = 42069
 
-const response400 = `<html>
-  <head>
-    <title>400 Bad Request</title>
-  </head>
-  <body>
-    <h1>Bad Request</h1>
-    <p>Your request honestly kinda sucked.</p>
-  </body>
-</html>`
-
-const response404 = `<html>
-  <head>
-    <title>404 Not Found</title>
-  </head>
-  <body>
-    <h1>Not Found</h1>
-    <p>You should try looking elsewhere.</p>
-  </body>
-</html>`
-
-const response500 = `<html>
-  <head>
-    <title>500 Internal Server Error</title>
-  </head>
-  <body>
-    <h1>Internal Server Error</h1>
-    <p>Okay, you know what? This one is on me.</p>
-  </body>
-</html>`
-
-const response200 = `<html>
-  <head>
-    <title>200 OK</title>
-  </head>
-  <body>
-    <h1>Success!</h1>
-    <p>Your request was an absolute banger.</p>
-  </body>
-</html>`
+//go:embed errors/*.html
+var errorPageFiles embed.FS
+
+//go:embed views
+var viewFiles embed.FS
+
+var views *view.Renderer
+
+//go:embed static
+var staticFiles embed.FS
+
+var staticServer = func() server.Handler {
+    staticFS, _ := fs.Sub(staticFiles, "static")
+    return fileserver.NewFS(staticFS, fileserver.Options{StripPrefix: "/static"})
+}()
+
+var videoServer = fileserver.New("assets", fileserver.Options{Precompressed: true})
 
 func reqHandler(w *response.Writer, req *request.Request) {
     h := response.GetDefaultHeaders(0)
-    target := req.RequestLine.RequestTarget
+    target := req.URL.Path
     h.Replace("Content-Type", "text/html")
 
-    var status int
-    var body []byte
-
     if target == "/yourproblem" {
-        status = 400
-        body = []byte(response400)
+        w.WriteProblem(req, response.NewProblem(400, ""), nil)
+        return
     } else if target == "/myproblem" {
-        status = 500
-        body = []byte(response500)
+        w.WriteProblem(req, response.NewProblem(500, ""), nil)
+        return
     } else if strings.HasPrefix(target, "/httpbin/stream") {
         requestURL := "https://httpbin.org" + strings.TrimPrefix(target, "/httpbin")
+        if req.URL.RawQuery != "" {
+            requestURL += "?" + req.URL.RawQuery
+        }
 
-        resp, err := http.Get(requestURL)
+        outReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, requestURL, nil)
+        var resp *http.Response
+        if err == nil {
+            resp, err = http.DefaultClient.Do(outReq)
+        }
         if err != nil {
-            status = 500
-            body = []byte(response500)
             fmt.Println(err.Error())
-        } else {
-            w.WriteChunksFromReader(resp.Body, h)
-            resp.Body.Close()
+            w.WriteProblem(req, response.NewProblem(500, "The upstream request failed."), nil)
             return
         }
+        w.WriteChunksFromReader(request.ContextReader(req.Context(), resp.Body), h)
+        resp.Body.Close()
+        return
+    } else if target == "/console" {
+        debugConsole(w, req)
+        return
+    } else if target == "/events" {
+        streamEvents(w, req, h)
+        return
     } else if strings.HasPrefix(target, "/video") {
-        f, err := os.Open("assets" + target)
-        info, infoErr := os.Stat("assets" + target)
-        if err != nil || infoErr != nil || info.IsDir() {
-            status = 404
-            body = []byte(response404)
-            var errMsg string
-            if err != nil {
-                errMsg = err.Error()
-            } else if infoErr != nil {
-                errMsg = infoErr.Error()
-            } else {
-                errMsg = fmt.Sprintf("trying to open directory %v", "assets" + target)
-            }
-            fmt.Println(errMsg)
-            f.Close()
-        } else {
-            w.WriteChunksFromReader(f, h)
-            f.Close()
+        videoServer(w, req)
+        return
+    } else if strings.HasPrefix(target, "/static/") {
+        staticServer(w, req)
+        return
+    } else if target != "/" {
+        server.NotFound(w, req)
+        return
+    }
+
+    views.Render(w, req, 200, "index", nil)
+}
+
+func debugConsole(w *response.Writer, req *request.Request) {
+    if !strings.EqualFold(req.Headers.Get("Upgrade"), "debug-console") {
+        w.WriteProblem(req, response.NewProblem(400, "Upgrade to debug-console to use the console."), nil)
+        return
+    }
+
+    conn, buffered, err := w.Hijack()
+    if err != nil {
+        fmt.Println(err.Error())
+        w.WriteProblem(req, response.NewProblem(400, "The console needs an HTTP/1.1 connection."), nil)
+        return
+    }
+    defer conn.Close()
+
+    err = response.NewWriter(conn).WriteSwitchingProtocols("debug-console", nil)
+    if err != nil {
+        return
+    }
+
+    scanner := bufio.NewScanner(io.MultiReader(bytes.NewReader(buffered), conn))
+    for scanner.Scan() {
+        var reply string
+        switch strings.TrimSpace(scanner.Text()) {
+        case "ping":
+            reply = "pong"
+        case "time":
+            reply = time.Now().Format(time.RFC3339)
+        case "quit":
+            fmt.Fprintln(conn, "bye")
             return
+        default:
+            reply = "unknown command, try ping, time or quit"
         }
+        if _, err := fmt.Fprintln(conn, reply); err != nil {
+            return
+        }
+    }
+}
 
-    } else {
-        status = 200
-        body = []byte(response200)
+func streamEvents(w *response.Writer, req *request.Request, h headers.Headers) {
+    sse, err := response.NewSSEWriter(w, req, h, response.DefaultSSEHeartbeat)
+    if err != nil {
+        fmt.Println(err.Error())
+        return
     }
+    defer sse.Close()
+
+    ticker := time.NewTicker(time.Second)
+    defer ticker.Stop()
 
-    w.WriteHttpMessage(status, h, body)
+    for i := 1; i <= 10; i++ {
+        select {
+        case <-sse.Done():
+            return
+        case t := <-ticker.C:
+            ev := response.SSEEvent{
+                ID: fmt.Sprintf("%d", i),
+                Event: "tick",
+                Data: t.Format(time.RFC3339),
+            }
+            if sse.Send(ev) != nil {
+                return
+            }
+        }
+    }
 }
 
 func main() {
-    server, err := server.Serve(port, reqHandler)
+    var srv *server.Server
+    var err error
+
+    viewsFS, _ := fs.Sub(viewFiles, "views")
+    views, err = view.New(viewsFS, view.Options{Layout: "base.html"})
+    if err != nil {
+        log.Fatalf("Error loading views: %v\n", err)
+    }
+
+    pagesFS, _ := fs.Sub(errorPageFiles, "errors")
+    errorPages, err := response.LoadErrorPages(pagesFS)
+    if err != nil {
+        log.Fatalf("Error loading error pages: %v\n", err)
+    }
+
+    handler := server.Chain(reqHandler,
+        compress.Middleware(compress.Options{}),
+        server.AllowMethods("GET"),
+        compress.DecompressMiddleware(compress.DecompressOptions{}),
+    )
+
+    certFile := os.Getenv("TLS_CERT_FILE")
+    keyFile := os.Getenv("TLS_KEY_FILE")
+    config := server.Config{ErrorPages: errorPages, StreamBodies: true}
+    if certFile != "" && keyFile != "" {
+        cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+        if err != nil {
+            log.Fatalf("Error loading certificate: %v\n", err)
+        }
+        config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+    }
+    srv, err = server.ServeConfig(port, handler, config)
 
     if err != nil {
         log.Fatalf("Error starting server: %v\n", err)
     }
-    defer server.Close()
+    defer srv.Close()
     log.Println("Server started on port", port)
 
     sigChan := make(chan os.Signal, 1)
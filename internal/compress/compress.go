@@ -0,0 +1,228 @@
+package compress
+
+import (
+    "compress/gzip"
+    "compress/zlib"
+    "io"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/aringq10/http-go-server/internal/server"
+)
+
+const DefaultMinSize = 1024
+
+// Media types that are already compressed or never worth compressing
+var skipTypes = []string{
+    "image/",
+    "video/",
+    "audio/",
+    "font/woff",
+    "text/event-stream",
+    "application/zip",
+    "application/gzip",
+    "application/x-gzip",
+    "application/x-bzip2",
+    "application/x-7z-compressed",
+    "application/x-xz",
+    "application/zstd",
+    "application/pdf",
+    "application/octet-stream",
+}
+
+// Compressible image types that would otherwise match "image/"
+var compressibleTypes = []string{
+    "image/svg+xml",
+    "image/bmp",
+}
+
+// Registry maps content-codings to their encoders.
+type Registry struct {
+    mu       sync.RWMutex
+    encoders map[string]response.BodyEncoder
+    // codings in order of preference
+    order    []string
+}
+
+// NewRegistry returns a registry with gzip and deflate.
+func NewRegistry() *Registry {
+    r := &Registry{encoders: make(map[string]response.BodyEncoder)}
+    r.Register("deflate", func(dst io.Writer) io.WriteCloser {
+        return zlib.NewWriter(dst)
+    })
+    r.Register("gzip", func(dst io.Writer) io.WriteCloser {
+        return gzip.NewWriter(dst)
+    })
+    return r
+}
+
+// Register adds or replaces the encoder for a content-coding, e.g. "br"
+// backed by an external brotli package. When a client accepts several
+// codings equally, the most recently registered one wins.
+func (r *Registry) Register(coding string, enc response.BodyEncoder) {
+    coding = strings.ToLower(coding)
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    order := []string{coding}
+    for _, c := range r.order {
+        if c != coding {
+            order = append(order, c)
+        }
+    }
+    r.order = order
+    r.encoders[coding] = enc
+}
+
+// Negotiate picks the coding to use for an Accept-Encoding header, "" means
+// the body should be sent as is.
+func (r *Registry) Negotiate(acceptEncoding string) (string, response.BodyEncoder) {
+    accepted := headers.ParseAccept(acceptEncoding)
+
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    best := ""
+    bestQ := 0.0
+    for _, coding := range r.order {
+        q, ok := headers.TokenQuality(accepted, coding)
+        if ok && q > bestQ {
+            best = coding
+            bestQ = q
+        }
+    }
+    if best == "" {
+        return "", nil
+    }
+
+    return best, r.encoders[best]
+}
+
+type Options struct {
+    // Registry defaults to gzip and deflate
+    Registry *Registry
+    // MinSize skips bodies with a known length below it, 0 means DefaultMinSize
+    MinSize int
+}
+
+// Middleware compresses responses with the best coding the client accepts.
+func Middleware(opts Options) server.Middleware {
+    if opts.Registry == nil {
+        opts.Registry = NewRegistry()
+    }
+    if opts.MinSize == 0 {
+        opts.MinSize = DefaultMinSize
+    }
+
+    return func(next server.Handler) server.Handler {
+        return func(w *response.Writer, req *request.Request) {
+            if req.RequestLine.Method != "HEAD" {
+                acceptEncoding := req.Headers.Get("Accept-Encoding")
+                // Clients send back the tag they got, with the coding the
+                // filter appended, handlers only know the plain one
+                coding, _ := opts.Registry.Negotiate(acceptEncoding)
+                revalidating := coding != "" && addUnencodedETags(req.Headers, coding)
+                w.AddFilter(filter(opts, acceptEncoding, revalidating))
+            }
+            next(w, req)
+            w.Finish()
+        }
+    }
+}
+
+// filter compresses the body. revalidating means the client's validators
+// named the encoded representation, so a 304 has to carry its tag too.
+func filter(opts Options, acceptEncoding string, revalidating bool) response.Filter {
+    return func(statusCode int, h headers.Headers) response.BodyEncoder {
+        if statusCode == 304 && revalidating && h.Get("Content-Encoding") == "" {
+            coding, _ := opts.Registry.Negotiate(acceptEncoding)
+            h.AddVary("Accept-Encoding")
+            h.Replace("ETag", etagWithCoding(h.Get("ETag"), coding))
+            return nil
+        }
+        if statusCode == 204 || statusCode == 304 || h.Get("Content-Range") != "" {
+            return nil
+        }
+        if h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+            return nil
+        }
+
+        // The representation depends on Accept-Encoding from here on,
+        // whether or not this client gets it compressed
+        h.AddVary("Accept-Encoding")
+
+        if length, err := strconv.Atoi(h.Get("Content-Length")); err == nil && length < opts.MinSize {
+            return nil
+        }
+
+        coding, enc := opts.Registry.Negotiate(acceptEncoding)
+        if enc == nil {
+            return nil
+        }
+
+        h.Replace("Content-Encoding", coding)
+        if etag := h.Get("ETag"); etag != "" {
+            // Each encoding is a different representation with its own tag
+            h.Replace("ETag", etagWithCoding(etag, coding))
+        }
+
+        return enc
+    }
+}
+
+func etagWithCoding(etag string, coding string) string {
+    if !strings.HasSuffix(etag, "\"") {
+        return etag
+    }
+    return strings.TrimSuffix(etag, "\"") + "-" + coding + "\""
+}
+
+// addUnencodedETags adds the tag without its -coding suffix next to each
+// one in If-None-Match and If-Match that has it, reporting whether any
+// did. The suffixed tags stay for handlers that encode by themselves,
+// like the file server's precompressed files.
+func addUnencodedETags(h headers.Headers, coding string) bool {
+    suffix := "-" + coding + "\""
+    added := false
+
+    for _, field := range []string{"If-None-Match", "If-Match"} {
+        list := h.Get(field)
+        if list == "" {
+            continue
+        }
+        tags := []string{}
+        for _, tag := range strings.Split(list, ",") {
+            tag = strings.TrimSpace(tag)
+            tags = append(tags, tag)
+            if strings.HasSuffix(tag, suffix) {
+                tags = append(tags, strings.TrimSuffix(tag, suffix) + "\"")
+                added = true
+            }
+        }
+        h.Replace(field, strings.Join(tags, ", "))
+    }
+
+    return added
+}
+
+func compressible(contentType string) bool {
+    mediaType, _, _ := strings.Cut(contentType, ";")
+    mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+    for _, t := range compressibleTypes {
+        if mediaType == t {
+            return true
+        }
+    }
+    for _, t := range skipTypes {
+        if strings.HasPrefix(mediaType, t) {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,258 @@
+package compress
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "compress/zlib"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "testing"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/aringq10/http-go-server/internal/server"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+var bigText = strings.Repeat("kakius bakius sikius makius ", 200)
+
+// serve runs handler behind the middleware and parses what it wrote
+func serve(t *testing.T, handler server.Handler, acceptEncoding string) *http.Response {
+    raw := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n"
+    if acceptEncoding != "" {
+        raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+    }
+    req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+    require.NoError(t, err)
+
+    buf := &bytes.Buffer{}
+    Middleware(Options{})(handler)(response.NewWriter(buf), req)
+
+    resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+    require.NoError(t, err)
+    return resp
+}
+
+func textHandler(body string, contentType string) server.Handler {
+    return func(w *response.Writer, req *request.Request) {
+        h := response.GetDefaultHeaders(0)
+        h.Replace("Content-Type", contentType)
+        w.WriteHttpMessage(200, h, []byte(body))
+    }
+}
+
+func TestCompressMiddleware(t *testing.T) {
+    // Test: gzip when accepted
+    resp := serve(t, textHandler(bigText, "text/plain"), "gzip")
+    assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+    assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+    assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+    gz, err := gzip.NewReader(resp.Body)
+    require.NoError(t, err)
+    body, err := io.ReadAll(gz)
+    require.NoError(t, err)
+    assert.Equal(t, bigText, string(body))
+
+    // Test: q-values pick deflate
+    resp = serve(t, textHandler(bigText, "text/plain"), "gzip;q=0.5, deflate")
+    assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+    zr, err := zlib.NewReader(resp.Body)
+    require.NoError(t, err)
+    body, err = io.ReadAll(zr)
+    require.NoError(t, err)
+    assert.Equal(t, bigText, string(body))
+
+    // Test: Wildcard
+    resp = serve(t, textHandler(bigText, "text/plain"), "*")
+    assert.NotEmpty(t, resp.Header.Get("Content-Encoding"))
+
+    // Test: Nothing acceptable
+    resp = serve(t, textHandler(bigText, "text/plain"), "gzip;q=0, br")
+    assert.Empty(t, resp.Header.Get("Content-Encoding"))
+    assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+    body, err = io.ReadAll(resp.Body)
+    require.NoError(t, err)
+    assert.Equal(t, bigText, string(body))
+
+    // Test: Small body is left alone
+    resp = serve(t, textHandler("tiny", "text/plain"), "gzip")
+    assert.Empty(t, resp.Header.Get("Content-Encoding"))
+    assert.Equal(t, int64(4), resp.ContentLength)
+
+    // Test: Already compressed media type is left alone
+    resp = serve(t, textHandler(bigText, "image/png"), "gzip")
+    assert.Empty(t, resp.Header.Get("Content-Encoding"))
+    assert.Empty(t, resp.Header.Get("Vary"))
+
+    // Test: Chunked handler output is compressed too
+    chunked := func(w *response.Writer, req *request.Request) {
+        h := response.GetDefaultHeaders(0)
+        h.Remove("Content-Length")
+        h.Set("Transfer-Encoding", "chunked")
+        w.WriteStatusLine(200)
+        w.WriteHeaders(h)
+        w.WriteChunkedBody([]byte(bigText[:100]))
+        w.WriteChunkedBody([]byte(bigText[100:]))
+        w.WriteChunkedBodyDone()
+        w.WriteHeaders(headers.NewHeaders())
+    }
+    resp = serve(t, chunked, "gzip")
+    assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+    gz, err = gzip.NewReader(resp.Body)
+    require.NoError(t, err)
+    body, err = io.ReadAll(gz)
+    require.NoError(t, err)
+    assert.Equal(t, bigText, string(body))
+}
+
+// serveRaw runs handler behind the middleware for a raw request
+func serveRaw(t *testing.T, handler server.Handler, raw string) *http.Response {
+    req, err := request.RequestFromReader(strings.NewReader(raw))
+    require.NoError(t, err)
+
+    buf := &bytes.Buffer{}
+    Middleware(Options{})(handler)(response.NewWriter(buf), req)
+
+    resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+    require.NoError(t, err)
+    return resp
+}
+
+func TestCompressRevalidation(t *testing.T) {
+    conditional := func(w *response.Writer, req *request.Request) {
+        w.WriteConditionalMessage(req, 200, response.GetDefaultHeaders(0), []byte(bigText))
+    }
+    get := func(handler server.Handler, extra string) *http.Response {
+        return serveRaw(t, handler, "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept-Encoding: gzip\r\n" + extra + "\r\n")
+    }
+
+    // Test: The tag of the compressed response gets the coding
+    resp := get(conditional, "")
+    assert.Equal(t, 200, resp.StatusCode)
+    etag := resp.Header.Get("ETag")
+    assert.Equal(t, strings.TrimSuffix(response.StrongETag([]byte(bigText)), "\"") + "-gzip\"", etag)
+
+    // Test: Sending it back revalidates, the 304 carries the same tag
+    resp = get(conditional, "If-None-Match: " + etag + "\r\n")
+    assert.Equal(t, 304, resp.StatusCode)
+    assert.Equal(t, etag, resp.Header.Get("ETag"))
+    assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+    assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+    // Test: Weak comparison and lists work the same
+    resp = get(conditional, "If-None-Match: \"other\", W/" + etag + "\r\n")
+    assert.Equal(t, 304, resp.StatusCode)
+
+    // Test: A tag for another coding doesn't match
+    resp = get(conditional, "If-None-Match: " + strings.Replace(etag, "-gzip", "-br", 1) + "\r\n")
+    assert.Equal(t, 200, resp.StatusCode)
+
+    // Test: If-Match with the encoded tag passes
+    resp = get(conditional, "If-Match: " + etag + "\r\n")
+    assert.Equal(t, 200, resp.StatusCode)
+
+    // Test: Handlers that encode by themselves still see their own tag
+    precompressed := func(w *response.Writer, req *request.Request) {
+        h := response.GetDefaultHeaders(0)
+        h.Replace("Content-Encoding", "gzip")
+        h.Replace("ETag", "\"file-gzip\"")
+        w.WriteConditionalMessage(req, 200, h, []byte("pretend gzip"))
+    }
+    resp = get(precompressed, "If-None-Match: \"file-gzip\"\r\n")
+    assert.Equal(t, 304, resp.StatusCode)
+    assert.Equal(t, "\"file-gzip\"", resp.Header.Get("ETag"))
+}
+
+func TestRegistry(t *testing.T) {
+    r := NewRegistry()
+
+    // Test: Ties go to the most recently registered coding
+    coding, _ := r.Negotiate("gzip, deflate")
+    assert.Equal(t, "gzip", coding)
+
+    r.Register("br", func(dst io.Writer) io.WriteCloser {
+        return gzip.NewWriter(dst)
+    })
+    coding, _ = r.Negotiate("gzip, deflate, br")
+    assert.Equal(t, "br", coding)
+
+    // Test: Explicit q-value beats preference
+    coding, _ = r.Negotiate("gzip, deflate, br;q=0.1")
+    assert.Equal(t, "gzip", coding)
+
+    // Test: Identity only
+    coding, enc := r.Negotiate("identity")
+    assert.Equal(t, "", coding)
+    assert.Nil(t, enc)
+}
+
+// upload runs an echo handler behind the decompression middleware
+func upload(t *testing.T, contentEncoding string, body []byte, maxSize int) (*http.Response, string) {
+    raw := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: %d\r\n", len(body))
+    if contentEncoding != "" {
+        raw += "Content-Encoding: " + contentEncoding + "\r\n"
+    }
+    req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n" + string(body)))
+    require.NoError(t, err)
+
+    echo := func(w *response.Writer, req *request.Request) {
+        w.WriteHttpMessage(200, response.GetDefaultHeaders(0), req.Body)
+    }
+
+    buf := &bytes.Buffer{}
+    DecompressMiddleware(DecompressOptions{MaxDecodedSize: maxSize})(echo)(response.NewWriter(buf), req)
+
+    resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+    require.NoError(t, err)
+    got, err := io.ReadAll(resp.Body)
+    require.NoError(t, err)
+    return resp, string(got)
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+    buf := &bytes.Buffer{}
+    gz := gzip.NewWriter(buf)
+    _, err := gz.Write(data)
+    require.NoError(t, err)
+    require.NoError(t, gz.Close())
+    return buf.Bytes()
+}
+
+func TestDecompressMiddleware(t *testing.T) {
+    // Test: gzip body
+    resp, body := upload(t, "gzip", gzipBytes(t, []byte(bigText)), 0)
+    assert.Equal(t, 200, resp.StatusCode)
+    assert.Equal(t, bigText, body)
+
+    // Test: Stacked codings are removed last first
+    buf := &bytes.Buffer{}
+    zw := zlib.NewWriter(buf)
+    zw.Write(gzipBytes(t, []byte(bigText)))
+    zw.Close()
+    resp, body = upload(t, "gzip, deflate", buf.Bytes(), 0)
+    assert.Equal(t, 200, resp.StatusCode)
+    assert.Equal(t, bigText, body)
+
+    // Test: No encoding passes through
+    resp, body = upload(t, "", []byte("plain"), 0)
+    assert.Equal(t, 200, resp.StatusCode)
+    assert.Equal(t, "plain", body)
+
+    // Test: Unsupported coding
+    resp, _ = upload(t, "br", []byte("whatever"), 0)
+    assert.Equal(t, 415, resp.StatusCode)
+    assert.Equal(t, "gzip, deflate", resp.Header.Get("Accept-Encoding"))
+
+    // Test: Zip bomb
+    resp, _ = upload(t, "gzip", gzipBytes(t, make([]byte, 1 << 20)), 1024)
+    assert.Equal(t, 413, resp.StatusCode)
+
+    // Test: Corrupt body
+    resp, _ = upload(t, "gzip", []byte("not gzip at all"), 0)
+    assert.Equal(t, 400, resp.StatusCode)
+}
@@ -0,0 +1,122 @@
+package compress
+
+import (
+    "bytes"
+    "compress/flate"
+    "compress/gzip"
+    "compress/zlib"
+    "errors"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/aringq10/http-go-server/internal/server"
+)
+
+const DefaultMaxDecodedSize = 10 << 20
+
+var (
+    ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+    ErrDecodedTooLarge     = errors.New("decoded body too large")
+)
+
+type DecompressOptions struct {
+    // MaxDecodedSize caps the body after every coding has been removed,
+    // 0 means DefaultMaxDecodedSize
+    MaxDecodedSize int
+}
+
+// DecompressMiddleware decodes request bodies sent with a Content-Encoding
+// before next sees them. Unknown codings get a 415 and bodies that inflate
+// past the limit a 413.
+func DecompressMiddleware(opts DecompressOptions) server.Middleware {
+    if opts.MaxDecodedSize == 0 {
+        opts.MaxDecodedSize = DefaultMaxDecodedSize
+    }
+
+    return func(next server.Handler) server.Handler {
+        return func(w *response.Writer, req *request.Request) {
+            contentEncoding := req.Headers.Get("Content-Encoding")
+            if contentEncoding == "" {
+                next(w, req)
+                return
+            }
+
+            // Encoded bodies past the decoded limit are refused as well
+            err := req.ReadBody(opts.MaxDecodedSize)
+            var body []byte
+            if err == nil {
+                body, err = DecodeBody(req.Body, contentEncoding, opts.MaxDecodedSize)
+            }
+            switch {
+            case errors.Is(err, ErrUnsupportedEncoding):
+                h := response.GetDefaultHeaders(0)
+                h.Replace("Accept-Encoding", "gzip, deflate")
+                w.WriteProblem(req, response.NewProblem(415, ""), h)
+                return
+            case errors.Is(err, ErrDecodedTooLarge), errors.Is(err, request.ErrBodyTooLarge):
+                w.WriteProblem(req, response.NewProblem(413, ""), nil)
+                return
+            case err != nil:
+                w.WriteProblem(req, response.NewProblem(400, ""), nil)
+                return
+            }
+
+            req.Body = body
+            req.Headers.Remove("Content-Encoding")
+            req.Headers.Replace("Content-Length", strconv.Itoa(len(body)))
+            next(w, req)
+        }
+    }
+}
+
+// DecodeBody removes the codings listed in a Content-Encoding header from
+// body, last applied first, never producing more than maxSize bytes.
+func DecodeBody(body []byte, contentEncoding string, maxSize int) ([]byte, error) {
+    codings := strings.Split(contentEncoding, ",")
+
+    for i := len(codings) - 1; i >= 0; i-- {
+        coding := strings.ToLower(strings.TrimSpace(codings[i]))
+
+        var r io.Reader
+        var err error
+        switch coding {
+        case "", "identity":
+            continue
+        case "gzip", "x-gzip":
+            r, err = gzip.NewReader(bytes.NewReader(body))
+        case "deflate":
+            r, err = newDeflateReader(body)
+        default:
+            return nil, fmt.Errorf("%w: %v", ErrUnsupportedEncoding, coding)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("error while decoding %v body: %w", coding, err)
+        }
+
+        // Read one byte past the limit to tell a full body from a cut one
+        decoded, err := io.ReadAll(io.LimitReader(r, int64(maxSize) + 1))
+        if err != nil {
+            return nil, fmt.Errorf("error while decoding %v body: %w", coding, err)
+        }
+        if len(decoded) > maxSize {
+            return nil, ErrDecodedTooLarge
+        }
+        body = decoded
+    }
+
+    return body, nil
+}
+
+// newDeflateReader accepts zlib wrapped data as the RFC requires and falls
+// back to raw deflate, which some clients send instead
+func newDeflateReader(body []byte) (io.Reader, error) {
+    r, err := zlib.NewReader(bytes.NewReader(body))
+    if err == nil {
+        return r, nil
+    }
+    return flate.NewReader(bytes.NewReader(body)), nil
+}
@@ -0,0 +1,192 @@
+package cookie
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+type SameSite int
+
+const (
+    // SameSiteDefault leaves the attribute out and lets the browser decide
+    SameSiteDefault SameSite = iota
+    SameSiteLax
+    SameSiteStrict
+    SameSiteNone
+)
+
+type Cookie struct {
+    Name  string
+    Value string
+
+    Path    string
+    Domain  string
+    // Expires is left out when zero
+    Expires time.Time
+    // MaxAge is left out when 0, a negative value deletes the cookie
+    MaxAge  int
+
+    Secure      bool
+    HttpOnly    bool
+    SameSite    SameSite
+    Partitioned bool
+}
+
+// Parse splits a Cookie request header into a name to value lookup. Pairs
+// that aren't valid are skipped and the first of duplicate names wins,
+// since browsers send the most specific path first.
+func Parse(header string) map[string]string {
+    cookies := map[string]string{}
+
+    for _, pair := range strings.Split(header, ";") {
+        name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+        if !ok || !validName(name) {
+            continue
+        }
+        if len(value) > 1 && value[0] == '"' && value[len(value) - 1] == '"' {
+            value = value[1:len(value) - 1]
+        }
+        if !validValue(value) {
+            continue
+        }
+        if _, exists := cookies[name]; !exists {
+            cookies[name] = value
+        }
+    }
+
+    return cookies
+}
+
+// Serialize returns the Set-Cookie field value for c, or an error if any
+// part of it can't be sent as is.
+func (c *Cookie) Serialize() (string, error) {
+    if err := c.validate(); err != nil {
+        return "", err
+    }
+
+    b := &strings.Builder{}
+    b.WriteString(c.Name + "=" + c.Value)
+
+    if c.Path != "" {
+        b.WriteString("; Path=" + c.Path)
+    }
+    if c.Domain != "" {
+        b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+    }
+    if !c.Expires.IsZero() {
+        b.WriteString("; Expires=" + c.Expires.UTC().Format(http.TimeFormat))
+    }
+    if c.MaxAge > 0 {
+        b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+    } else if c.MaxAge < 0 {
+        b.WriteString("; Max-Age=0")
+    }
+    if c.Secure {
+        b.WriteString("; Secure")
+    }
+    if c.HttpOnly {
+        b.WriteString("; HttpOnly")
+    }
+    switch c.SameSite {
+    case SameSiteLax:
+        b.WriteString("; SameSite=Lax")
+    case SameSiteStrict:
+        b.WriteString("; SameSite=Strict")
+    case SameSiteNone:
+        b.WriteString("; SameSite=None")
+    }
+    if c.Partitioned {
+        b.WriteString("; Partitioned")
+    }
+
+    return b.String(), nil
+}
+
+func (c *Cookie) validate() error {
+    if !validName(c.Name) {
+        return fmt.Errorf("invalid cookie name %q", c.Name)
+    }
+    if !validValue(c.Value) {
+        return fmt.Errorf("invalid value for cookie %v", c.Name)
+    }
+    if !validAttribute(c.Path) {
+        return fmt.Errorf("invalid path for cookie %v", c.Name)
+    }
+    if !validDomain(strings.TrimPrefix(c.Domain, ".")) {
+        return fmt.Errorf("invalid domain for cookie %v", c.Name)
+    }
+    if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+        return fmt.Errorf("invalid expiry for cookie %v", c.Name)
+    }
+
+    // Browsers drop these without Secure, better to fail loudly here
+    if c.SameSite == SameSiteNone && !c.Secure {
+        return errors.New("SameSite=None requires Secure")
+    }
+    if c.Partitioned && !c.Secure {
+        return errors.New("Partitioned requires Secure")
+    }
+    if strings.HasPrefix(c.Name, "__Secure-") && !c.Secure {
+        return errors.New("__Secure- cookies require Secure")
+    }
+    if strings.HasPrefix(c.Name, "__Host-") && (!c.Secure || c.Path != "/" || c.Domain != "") {
+        return errors.New("__Host- cookies require Secure, Path=/ and no Domain")
+    }
+
+    return nil
+}
+
+// A cookie name is an RFC 9110 token
+func validName(name string) bool {
+    if name == "" {
+        return false
+    }
+    for i := 0; i < len(name); i++ {
+        c := name[i]
+        if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),/:;<=>?@[\\]{}", c) >= 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// validValue checks for the cookie-octets of RFC 6265 section 4.1.1, which
+// leave out whitespace, DQUOTE, comma, semicolon and backslash
+func validValue(value string) bool {
+    for i := 0; i < len(value); i++ {
+        c := value[i]
+        if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+            return false
+        }
+    }
+    return true
+}
+
+// Attribute values may be anything but control characters and ';'
+func validAttribute(value string) bool {
+    for i := 0; i < len(value); i++ {
+        c := value[i]
+        if c < ' ' || c == 0x7f || c == ';' {
+            return false
+        }
+    }
+    return true
+}
+
+func validDomain(domain string) bool {
+    if len(domain) > 255 {
+        return false
+    }
+    for i := 0; i < len(domain); i++ {
+        c := domain[i]
+        isAlnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+        if !isAlnum && c != '-' && c != '.' {
+            return false
+        }
+    }
+    return true
+}
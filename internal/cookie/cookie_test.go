@@ -0,0 +1,62 @@
+package cookie
+
+import (
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+    // Test: Several cookies
+    cookies := Parse("session=abc123; theme=dark;lang=\"en\"")
+    assert.Equal(t, map[string]string{"session": "abc123", "theme": "dark", "lang": "en"}, cookies)
+
+    // Test: First duplicate wins, invalid pairs are skipped
+    cookies = Parse("id=first; id=second; bad name=x; noequals; ok=va\\lue; empty=")
+    assert.Equal(t, map[string]string{"id": "first", "empty": ""}, cookies)
+
+    // Test: Empty header
+    assert.Empty(t, Parse(""))
+}
+
+func TestSerialize(t *testing.T) {
+    // Test: Every attribute
+    c := &Cookie{
+        Name: "__Host-session",
+        Value: "abc123",
+        Path: "/",
+        Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+        MaxAge: 3600,
+        Secure: true,
+        HttpOnly: true,
+        SameSite: SameSiteNone,
+        Partitioned: true,
+    }
+    s, err := c.Serialize()
+    require.NoError(t, err)
+    assert.Equal(t, "__Host-session=abc123; Path=/; Expires=Wed, 02 Jan 2030 03:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", s)
+
+    // Test: Deletion
+    s, err = (&Cookie{Name: "id", Domain: ".example.com", MaxAge: -1}).Serialize()
+    require.NoError(t, err)
+    assert.Equal(t, "id=; Domain=example.com; Max-Age=0", s)
+
+    // Test: Invalid cookies
+    invalid := []*Cookie{
+        {Name: "", Value: "x"},
+        {Name: "a;b", Value: "x"},
+        {Name: "id", Value: "has space"},
+        {Name: "id", Value: "x", Path: "/a;injected"},
+        {Name: "id", Value: "x", Domain: "exa mple.com"},
+        {Name: "id", Value: "x", SameSite: SameSiteNone},
+        {Name: "id", Value: "x", Partitioned: true},
+        {Name: "__Secure-id", Value: "x"},
+        {Name: "__Host-id", Value: "x", Secure: true, Path: "/app"},
+    }
+    for _, c := range invalid {
+        _, err := c.Serialize()
+        assert.Error(t, err, c.Name)
+    }
+}
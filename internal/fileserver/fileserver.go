@@ -0,0 +1,573 @@
+package fileserver
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "html/template"
+    "io"
+    "io/fs"
+    "mime"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/aringq10/http-go-server/internal/server"
+)
+
+const sniffLen = 512
+
+// What to do with symlinks found under the root
+const (
+    // SymlinksWithinRoot follows links as long as they resolve inside the root
+    SymlinksWithinRoot int = iota
+    // SymlinksDeny refuses any path that goes through a link
+    SymlinksDeny
+    // SymlinksFollow follows links wherever they point
+    SymlinksFollow
+)
+
+type Options struct {
+    // StripPrefix is removed from the request path before looking up files
+    StripPrefix string
+    Symlinks int
+    // ListDirectories renders an index for directories without index.html
+    ListDirectories bool
+    // StrongETags hashes file contents for ETags instead of using a weak
+    // tag made from the modification time and size
+    StrongETags bool
+    // Precompressed serves a sibling name.br or name.gz in place of name
+    // when the client accepts that encoding
+    Precompressed bool
+    // SPA serves the root index.html for paths that match no file, so a
+    // single page app can do its own routing. Paths with an extension
+    // still 404, a missing script shouldn't come back as HTML.
+    SPA bool
+    // ModTime stands in for files that don't have one, like those in an
+    // embed.FS, typically the build time. Without it such files get ETags
+    // hashed from their contents and no Last-Modified.
+    ModTime time.Time
+}
+
+// Sibling extensions checked when Precompressed is set, preferred first
+var precompressedExts = []struct {
+    coding string
+    ext    string
+}{
+    {"br", ".br"},
+    {"gzip", ".gz"},
+}
+
+type fileServer struct {
+    root string
+    // fsys is set for servers made with NewFS, names are fs paths then
+    fsys fs.FS
+    opts Options
+    // etags caches content hashes by name, see hashedETag
+    etagsMu sync.Mutex
+    etags map[string]hashedETag
+}
+
+// hashedETag is a content hash and the size and modtime of the file it was
+// computed from
+type hashedETag struct {
+    size int64
+    modTime time.Time
+    etag string
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<html>
+  <head>
+    <title>Index of {{.Path}}</title>
+  </head>
+  <body>
+    <h1>Index of {{.Path}}</h1>
+    <ul>
+{{- range .Entries}}
+      <li><a href="{{.}}">{{.}}</a></li>
+{{- end}}
+    </ul>
+  </body>
+</html>
+`))
+
+// FileServer serves the files under root.
+func FileServer(root string) server.Handler {
+    return New(root, Options{})
+}
+
+func New(root string, opts Options) server.Handler {
+    fsrv := &fileServer{root: root, opts: opts, etags: map[string]hashedETag{}}
+    return fsrv.serve
+}
+
+// NewFS serves the files in fsys, e.g. an embed.FS sub-tree, so assets can
+// ship inside the binary. Symlinks are left to fsys.
+func NewFS(fsys fs.FS, opts Options) server.Handler {
+    fsrv := &fileServer{fsys: fsys, opts: opts, etags: map[string]hashedETag{}}
+    return fsrv.serve
+}
+
+func (fsrv *fileServer) serve(w *response.Writer, req *request.Request) {
+    method := req.RequestLine.Method
+    if method != "GET" && method != "HEAD" {
+        h := response.GetDefaultHeaders(0)
+        h.Set("Allow", "GET, HEAD")
+        writeError(w, req, 405, h)
+        return
+    }
+
+    urlPath := req.URL.Path
+    if fsrv.opts.StripPrefix != "" {
+        // "/static" covers "/static" and "/static/x" but not "/staticfoo"
+        rest, ok := strings.CutPrefix(urlPath, strings.TrimSuffix(fsrv.opts.StripPrefix, "/"))
+        if !ok || (rest != "" && rest[0] != '/') {
+            writeError(w, req, 404, nil)
+            return
+        }
+        urlPath = "/" + strings.TrimPrefix(rest, "/")
+    }
+
+    name, status := fsrv.resolve(urlPath)
+    var info fs.FileInfo
+    if status == 200 {
+        var err error
+        if info, err = fsrv.stat(name); err != nil {
+            status = statusForError(err)
+        }
+    }
+    if status == 404 && fsrv.opts.SPA && path.Ext(urlPath) == "" {
+        fsrv.serveFallback(w, req)
+        return
+    }
+    if status != 200 {
+        writeError(w, req, status, nil)
+        return
+    }
+
+    if info.IsDir() {
+        if !strings.HasSuffix(req.URL.Path, "/") {
+            // Relative links in the directory only work with the slash
+            h := response.GetDefaultHeaders(0)
+            h.Set("Location", req.URL.Path + "/")
+            w.WriteHttpMessage(301, h, []byte("301 Moved Permanently\n"))
+            return
+        }
+
+        index, status := fsrv.resolve(path.Join(urlPath, "index.html"))
+        if status == 200 {
+            if indexInfo, err := fsrv.stat(index); err == nil && !indexInfo.IsDir() {
+                fsrv.serveFile(w, req, path.Join(urlPath, "index.html"), index, indexInfo)
+                return
+            }
+        }
+
+        if !fsrv.opts.ListDirectories {
+            writeError(w, req, 403, nil)
+            return
+        }
+        fsrv.serveListing(w, req, name)
+        return
+    }
+
+    fsrv.serveFile(w, req, urlPath, name, info)
+}
+
+// serveFallback answers a path that matches no file with the root
+// index.html when SPA is set
+func (fsrv *fileServer) serveFallback(w *response.Writer, req *request.Request) {
+    index, status := fsrv.resolve("/index.html")
+    if status != 200 {
+        writeError(w, req, status, nil)
+        return
+    }
+    info, err := fsrv.stat(index)
+    if err != nil || !info.Mode().IsRegular() {
+        writeError(w, req, 404, nil)
+        return
+    }
+    fsrv.serveFile(w, req, "/index.html", index, info)
+}
+
+// resolve maps a cleaned URL path to a file name confined to the root
+func (fsrv *fileServer) resolve(urlPath string) (string, int) {
+    // Cleaning a rooted path can't climb above it, this is a second guard
+    cleaned := path.Clean("/" + urlPath)
+    if strings.Contains(cleaned, "\x00") {
+        return "", 400
+    }
+    if fsrv.fsys != nil {
+        if cleaned == "/" {
+            return ".", 200
+        }
+        return cleaned[1:], 200
+    }
+    name := filepath.Join(fsrv.root, filepath.FromSlash(cleaned))
+
+    if fsrv.opts.Symlinks == SymlinksFollow {
+        return name, 200
+    }
+
+    root, err := filepath.EvalSymlinks(fsrv.root)
+    if err != nil {
+        return "", statusForError(err)
+    }
+    resolved, err := filepath.EvalSymlinks(name)
+    if err != nil {
+        return "", statusForError(err)
+    }
+
+    if fsrv.opts.Symlinks == SymlinksDeny {
+        if resolved != filepath.Join(root, filepath.FromSlash(cleaned)) {
+            return "", 403
+        }
+        return name, 200
+    }
+
+    rel, err := filepath.Rel(root, resolved)
+    if err != nil || rel == ".." || strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+        return "", 403
+    }
+
+    return name, 200
+}
+
+func (fsrv *fileServer) serveFile(w *response.Writer, req *request.Request, urlPath string, name string, info fs.FileInfo) {
+    f, err := fsrv.open(name)
+    if err != nil {
+        writeError(w, req, statusForError(err), nil)
+        return
+    }
+    // f may be swapped below, close whichever is current
+    defer func() {
+        if f != nil {
+            f.Close()
+        }
+    }()
+
+    contentType, err := detectContentType(name, f)
+    if err == nil {
+        f, err = fsrv.rewind(f, name)
+    }
+    if err != nil {
+        writeError(w, req, 500, nil)
+        return
+    }
+
+    coding, vary := "", false
+    if fsrv.opts.Precompressed {
+        var variant string
+        var variantInfo fs.FileInfo
+        variant, variantInfo, coding, vary = fsrv.precompressed(urlPath, req.Headers.Get("Accept-Encoding"))
+        if coding != "" {
+            vf, err := fsrv.open(variant)
+            if err != nil {
+                writeError(w, req, statusForError(err), nil)
+                return
+            }
+            f.Close()
+            // Sizes, validators and ranges all refer to the encoded bytes
+            f, name, info = vf, variant, variantInfo
+        }
+    }
+
+    size := info.Size()
+    modTime := info.ModTime()
+    if modTime.IsZero() {
+        modTime = fsrv.opts.ModTime
+    }
+    lastModified := ""
+    if !modTime.IsZero() {
+        lastModified = modTime.UTC().Format(http.TimeFormat)
+    }
+    etag := ""
+    if fsrv.opts.StrongETags || modTime.IsZero() {
+        var hashed bool
+        etag, hashed, err = fsrv.contentETag(f, name, info)
+        if err == nil && hashed {
+            f, err = fsrv.rewind(f, name)
+        }
+        if err != nil {
+            writeError(w, req, 500, nil)
+            return
+        }
+    } else {
+        etag = response.WeakETag(modTime, size)
+    }
+    if coding != "" {
+        etag = strings.TrimSuffix(etag, "\"") + "-" + coding + "\""
+    }
+
+    h := response.GetDefaultHeaders(int(size))
+    h.Replace("Content-Type", contentType)
+    // Ranges need seeking, files that can't are always sent whole
+    seeker, seekable := f.(io.Seeker)
+    if seekable {
+        h.Set("Accept-Ranges", "bytes")
+    }
+    if lastModified != "" {
+        h.Set("Last-Modified", lastModified)
+    }
+    h.Set("ETag", etag)
+    if coding != "" {
+        h.Set("Content-Encoding", coding)
+    }
+    if vary {
+        h.AddVary("Accept-Encoding")
+    }
+
+    if status := response.CheckPreconditions(req, etag, modTime); status != 200 {
+        w.WritePreconditionFailure(status, h)
+        return
+    }
+
+    ranges := []byteRange{}
+    rangeHeader := req.Headers.Get("Range")
+    if rangeHeader != "" && seekable && ifRangeMatches(req.Headers.Get("If-Range"), etag, lastModified) {
+        ranges, err = parseRange(rangeHeader, size)
+        if errors.Is(err, errRangeUnsatisfiable) {
+            eh := response.GetDefaultHeaders(0)
+            eh.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+            writeError(w, req, 416, eh)
+            return
+        }
+        if err != nil {
+            // Malformed ranges are ignored and the whole file is sent
+            ranges = []byteRange{}
+        }
+    }
+
+    var multipart *multipartLayout
+    status := 200
+    switch {
+    case len(ranges) == 1:
+        status = 206
+        h.Replace("Content-Length", fmt.Sprintf("%d", ranges[0].length))
+        h.Set("Content-Range", ranges[0].contentRange(size))
+    case len(ranges) > 1:
+        status = 206
+        multipart, err = newMultipartLayout(ranges, contentType, size)
+        if err != nil {
+            writeError(w, req, 500, nil)
+            return
+        }
+        h.Replace("Content-Type", "multipart/byteranges; boundary=" + multipart.boundary)
+        h.Replace("Content-Length", fmt.Sprintf("%d", multipart.contentLength(ranges)))
+    }
+
+    if err := w.WriteStatusLine(status); err != nil {
+        return
+    }
+    if err := w.WriteHeaders(h); err != nil {
+        return
+    }
+    if req.RequestLine.Method == "HEAD" {
+        return
+    }
+
+    body := request.ContextReader(req.Context(), f)
+    switch {
+    case len(ranges) == 0:
+        io.CopyN(w, body, size)
+    case multipart == nil:
+        if _, err := seeker.Seek(ranges[0].start, io.SeekStart); err != nil {
+            return
+        }
+        io.CopyN(w, body, ranges[0].length)
+    default:
+        for i, r := range ranges {
+            if _, err := w.WriteBody([]byte(multipart.headers[i])); err != nil {
+                return
+            }
+            if _, err := seeker.Seek(r.start, io.SeekStart); err != nil {
+                return
+            }
+            if _, err := io.CopyN(w, body, r.length); err != nil {
+                return
+            }
+        }
+        w.WriteBody([]byte(multipart.closing))
+    }
+}
+
+// precompressed looks for encoded siblings of urlPath and picks the one the
+// client prefers. vary reports whether any sibling exists, in which case
+// the response depends on Accept-Encoding even if coding is "".
+func (fsrv *fileServer) precompressed(urlPath string, acceptEncoding string) (name string, info fs.FileInfo, coding string, vary bool) {
+    accepted := headers.ParseAccept(acceptEncoding)
+    bestQ := 0.0
+
+    for _, pc := range precompressedExts {
+        candidate, status := fsrv.resolve(urlPath + pc.ext)
+        if status != 200 {
+            continue
+        }
+        candidateInfo, err := fsrv.stat(candidate)
+        if err != nil || !candidateInfo.Mode().IsRegular() {
+            continue
+        }
+        vary = true
+
+        q, ok := headers.TokenQuality(accepted, pc.coding)
+        if ok && q > bestQ {
+            name, info, coding, bestQ = candidate, candidateInfo, pc.coding, q
+        }
+    }
+
+    return
+}
+
+// ifRangeMatches reports whether a Range header still applies. An If-Range
+// entity tag must match strongly and a date must match Last-Modified
+// exactly, anything else means the client's copy may be stale and the
+// whole file has to be sent.
+func ifRangeMatches(ifRange string, etag string, lastModified string) bool {
+    if ifRange == "" {
+        return true
+    }
+    if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+        return response.ETagMatchesStrong(ifRange, etag)
+    }
+    t, err := http.ParseTime(ifRange)
+    if err != nil {
+        return false
+    }
+    modTime, err := http.ParseTime(lastModified)
+    if err != nil {
+        return false
+    }
+    return t.Equal(modTime)
+}
+
+// contentETag returns a strong tag hashed from f's contents. Hashes are
+// reused while the file keeps its size and modtime, so files without a
+// modtime are only hashed once, their fs is taken to be immutable. hashed
+// reports whether f was read.
+func (fsrv *fileServer) contentETag(f io.Reader, name string, info fs.FileInfo) (etag string, hashed bool, err error) {
+    fsrv.etagsMu.Lock()
+    cached, ok := fsrv.etags[name]
+    fsrv.etagsMu.Unlock()
+    if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+        return cached.etag, false, nil
+    }
+
+    hash := sha256.New()
+    if _, err := io.Copy(hash, f); err != nil {
+        return "", true, err
+    }
+    etag = fmt.Sprintf("\"%v\"", hex.EncodeToString(hash.Sum(nil)[:16]))
+
+    fsrv.etagsMu.Lock()
+    fsrv.etags[name] = hashedETag{info.Size(), info.ModTime(), etag}
+    fsrv.etagsMu.Unlock()
+    return etag, true, nil
+}
+
+func (fsrv *fileServer) open(name string) (fs.File, error) {
+    if fsrv.fsys != nil {
+        return fsrv.fsys.Open(name)
+    }
+    return os.Open(name)
+}
+
+func (fsrv *fileServer) stat(name string) (fs.FileInfo, error) {
+    if fsrv.fsys != nil {
+        return fs.Stat(fsrv.fsys, name)
+    }
+    return os.Stat(name)
+}
+
+// rewind puts f back at its start. Files that can't seek are reopened.
+func (fsrv *fileServer) rewind(f fs.File, name string) (fs.File, error) {
+    if seeker, ok := f.(io.Seeker); ok {
+        _, err := seeker.Seek(0, io.SeekStart)
+        return f, err
+    }
+    f.Close()
+    return fsrv.open(name)
+}
+
+func (fsrv *fileServer) serveListing(w *response.Writer, req *request.Request, dir string) {
+    var entries []fs.DirEntry
+    var err error
+    if fsrv.fsys != nil {
+        entries, err = fs.ReadDir(fsrv.fsys, dir)
+    } else {
+        entries, err = os.ReadDir(dir)
+    }
+    if err != nil {
+        writeError(w, req, statusForError(err), nil)
+        return
+    }
+
+    names := []string{}
+    for _, entry := range entries {
+        name := entry.Name()
+        if entry.IsDir() {
+            name += "/"
+        }
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    body := &strings.Builder{}
+    data := struct {
+        Path    string
+        Entries []string
+    }{req.URL.Path, names}
+    if err := listingTemplate.Execute(body, data); err != nil {
+        writeError(w, req, 500, nil)
+        return
+    }
+
+    h := response.GetDefaultHeaders(0)
+    h.Replace("Content-Type", "text/html; charset=utf-8")
+    if req.RequestLine.Method == "HEAD" {
+        h.Replace("Content-Length", fmt.Sprintf("%d", body.Len()))
+        w.WriteStatusLine(200)
+        w.WriteHeaders(h)
+        return
+    }
+    w.WriteHttpMessage(200, h, []byte(body.String()))
+}
+
+// detectContentType goes by the extension first and sniffs the content
+// when the extension is unknown. f has to be rewound afterwards.
+func detectContentType(name string, f io.Reader) (string, error) {
+    if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+        return contentType, nil
+    }
+
+    buf := make([]byte, sniffLen)
+    n, err := io.ReadFull(f, buf)
+    if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+        return "", err
+    }
+
+    return http.DetectContentType(buf[:n]), nil
+}
+
+func statusForError(err error) int {
+    switch {
+    case errors.Is(err, fs.ErrNotExist), errors.Is(err, syscall.ENOTDIR):
+        return 404
+    case errors.Is(err, fs.ErrPermission):
+        return 403
+    default:
+        return 500
+    }
+}
+
+func writeError(w *response.Writer, req *request.Request, status int, h headers.Headers) {
+    w.WriteProblem(req, response.NewProblem(status, ""), h)
+}
@@ -0,0 +1,452 @@
+package fileserver
+
+import (
+    "bytes"
+    "io/fs"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "testing/fstest"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/aringq10/http-go-server/internal/server"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// serve runs handler for a raw request and returns the raw response
+func serve(t *testing.T, handler server.Handler, rawRequest string) string {
+    req, err := request.RequestFromReader(strings.NewReader(rawRequest))
+    require.NoError(t, err)
+    buf := &bytes.Buffer{}
+    handler(response.NewWriter(buf), req)
+    return buf.String()
+}
+
+func get(target string) string {
+    return "GET " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+}
+
+func setupRoot(t *testing.T) (root string, outside string) {
+    base := t.TempDir()
+    root = filepath.Join(base, "assets")
+    outside = filepath.Join(base, "secret")
+    require.NoError(t, os.MkdirAll(filepath.Join(root, "video"), 0755))
+    require.NoError(t, os.MkdirAll(filepath.Join(root, "site"), 0755))
+    require.NoError(t, os.MkdirAll(outside, 0755))
+    require.NoError(t, os.WriteFile(filepath.Join(root, "video", "clip.mp4"), []byte("not really a video"), 0644))
+    require.NoError(t, os.WriteFile(filepath.Join(root, "video", "notes"), []byte("<html><body>hi</body></html>"), 0644))
+    require.NoError(t, os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<h1>home</h1>"), 0644))
+    require.NoError(t, os.WriteFile(filepath.Join(outside, "passwd"), []byte("root:x:0:0"), 0644))
+    require.NoError(t, os.Symlink(filepath.Join(outside, "passwd"), filepath.Join(root, "video", "escape")))
+    require.NoError(t, os.Symlink(filepath.Join(root, "video", "clip.mp4"), filepath.Join(root, "video", "alias.mp4")))
+    return root, outside
+}
+
+func TestFileServer(t *testing.T) {
+    root, _ := setupRoot(t)
+    handler := FileServer(root)
+
+    // Test: Regular file with type from extension
+    resp := serve(t, handler, get("/video/clip.mp4"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+    assert.Contains(t, resp, "content-type: video/mp4\r\n")
+    assert.Contains(t, resp, "content-length: 18\r\n")
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\nnot really a video"))
+
+    // Test: Type sniffed from content
+    resp = serve(t, handler, get("/video/notes"))
+    assert.Contains(t, resp, "content-type: text/html; charset=utf-8\r\n")
+
+    // Test: Traversal can't leave the root
+    resp = serve(t, handler, get("/video/../../secret/passwd"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+    assert.NotContains(t, resp, "root:x")
+
+    // Test: Encoded traversal can't leave the root either
+    resp = serve(t, handler, get("/video/%2e%2e/%2e%2e/secret/passwd"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+    // Test: Symlink pointing outside the root
+    resp = serve(t, handler, get("/video/escape"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+
+    // Test: Symlink inside the root
+    resp = serve(t, handler, get("/video/alias.mp4"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: Missing file
+    resp = serve(t, handler, get("/video/nope.mp4"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+    // Test: Directory without trailing slash redirects
+    resp = serve(t, handler, get("/site"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 301 Moved Permanently\r\n"))
+    assert.Contains(t, resp, "location: /site/\r\n")
+
+    // Test: Directory serves index.html
+    resp = serve(t, handler, get("/site/"))
+    assert.True(t, strings.HasSuffix(resp, "<h1>home</h1>"))
+
+    // Test: Directory without index and listings off
+    resp = serve(t, handler, get("/video/"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+
+    // Test: Method other than GET or HEAD
+    resp = serve(t, handler, "DELETE /video/clip.mp4 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed\r\n"))
+    assert.Contains(t, resp, "allow: GET, HEAD\r\n")
+
+    // Test: HEAD has no body
+    resp = serve(t, handler, "HEAD /video/clip.mp4 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+    assert.Contains(t, resp, "content-length: 18\r\n")
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+}
+
+func TestFileServerOptions(t *testing.T) {
+    root, _ := setupRoot(t)
+
+    // Test: Directory listing
+    handler := New(root, Options{ListDirectories: true})
+    resp := serve(t, handler, get("/video/"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+    assert.Contains(t, resp, `<a href="clip.mp4">clip.mp4</a>`)
+
+    // Test: Symlinks denied altogether
+    handler = New(root, Options{Symlinks: SymlinksDeny})
+    resp = serve(t, handler, get("/video/alias.mp4"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+    resp = serve(t, handler, get("/video/clip.mp4"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: Symlinks followed anywhere
+    handler = New(root, Options{Symlinks: SymlinksFollow})
+    resp = serve(t, handler, get("/video/escape"))
+    assert.True(t, strings.HasSuffix(resp, "root:x:0:0"))
+
+    // Test: Prefix stripped before lookup
+    handler = New(filepath.Join(root, "video"), Options{StripPrefix: "/media"})
+    resp = serve(t, handler, get("/media/clip.mp4"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: A sibling path sharing the prefix's leading bytes isn't stripped
+    handler = New(root, Options{StripPrefix: "/media"})
+    resp = serve(t, handler, get("/mediavideo/clip.mp4"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+    resp = serve(t, handler, get("/media/video/clip.mp4"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: A trailing slash on the prefix makes no difference
+    handler = New(root, Options{StripPrefix: "/media/"})
+    resp = serve(t, handler, get("/mediavideo/clip.mp4"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+    resp = serve(t, handler, get("/media/video/clip.mp4"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+}
+
+func getRange(target string, extra string) string {
+    return "GET " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n" + extra + "\r\n"
+}
+
+func TestFileServerRanges(t *testing.T) {
+    root, _ := setupRoot(t)
+    handler := FileServer(root)
+
+    // Test: Whole file advertises range support
+    resp := serve(t, handler, get("/video/clip.mp4"))
+    assert.Contains(t, resp, "accept-ranges: bytes\r\n")
+    assert.Contains(t, resp, "last-modified: ")
+
+    // Test: Single range
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=4-9\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+    assert.Contains(t, resp, "content-range: bytes 4-9/18\r\n")
+    assert.Contains(t, resp, "content-length: 6\r\n")
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\nreally"))
+
+    // Test: Open ended and suffix ranges
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=13-\r\n"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\nvideo"))
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=-5\r\n"))
+    assert.Contains(t, resp, "content-range: bytes 13-17/18\r\n")
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\nvideo"))
+
+    // Test: Last position past the end is clipped
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=13-100\r\n"))
+    assert.Contains(t, resp, "content-range: bytes 13-17/18\r\n")
+
+    // Test: Multiple ranges
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=0-2, 13-17\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+    assert.Contains(t, resp, "content-type: multipart/byteranges; boundary=")
+    assert.Contains(t, resp, "Content-Range: bytes 0-2/18\r\n\r\nnot\r\n--")
+    assert.Contains(t, resp, "Content-Range: bytes 13-17/18\r\n\r\nvideo\r\n--")
+    _, body, _ := strings.Cut(resp, "\r\n\r\n")
+    assert.Equal(t, strconv.Itoa(len(body)), headerValue(resp, "content-length"))
+
+    // Test: Unsatisfiable range
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=100-200\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+    assert.Contains(t, resp, "content-range: bytes */18\r\n")
+
+    // Test: Malformed range is ignored
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=9-4\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: items=0-1\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: If-Range with a stale date sends the whole file
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=4-9\r\nIf-Range: Mon, 02 Jan 2006 15:04:05 GMT\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: If-Range with the current date honours the range
+    info, err := os.Stat(filepath.Join(root, "video", "clip.mp4"))
+    require.NoError(t, err)
+    lastModified := info.ModTime().UTC().Format(http.TimeFormat)
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=4-9\r\nIf-Range: " + lastModified + "\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+}
+
+func headerValue(resp string, name string) string {
+    head, _, _ := strings.Cut(resp, "\r\n\r\n")
+    for _, line := range strings.Split(head, "\r\n") {
+        if value, ok := strings.CutPrefix(line, name + ": "); ok {
+            return value
+        }
+    }
+    return ""
+}
+
+func TestFileServerConditional(t *testing.T) {
+    root, _ := setupRoot(t)
+    handler := FileServer(root)
+
+    resp := serve(t, handler, get("/video/clip.mp4"))
+    etag := headerValue(resp, "etag")
+    lastModified := headerValue(resp, "last-modified")
+    require.True(t, strings.HasPrefix(etag, "W/\""))
+    require.NotEmpty(t, lastModified)
+
+    // Test: If-None-Match with the current tag
+    resp = serve(t, handler, getRange("/video/clip.mp4", "If-None-Match: \"other\", " + etag + "\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+    assert.Equal(t, etag, headerValue(resp, "etag"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+    // Test: If-None-Match takes precedence over If-Modified-Since
+    resp = serve(t, handler, getRange("/video/clip.mp4", "If-None-Match: \"other\"\r\nIf-Modified-Since: " + lastModified + "\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: If-Modified-Since
+    resp = serve(t, handler, getRange("/video/clip.mp4", "If-Modified-Since: " + lastModified + "\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+    resp = serve(t, handler, getRange("/video/clip.mp4", "If-Modified-Since: Mon, 02 Jan 2006 15:04:05 GMT\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: If-Match never matches a weak tag
+    resp = serve(t, handler, getRange("/video/clip.mp4", "If-Match: " + etag + "\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 412 Precondition Failed\r\n"))
+    resp = serve(t, handler, getRange("/video/clip.mp4", "If-Match: *\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: If-Unmodified-Since in the past
+    resp = serve(t, handler, getRange("/video/clip.mp4", "If-Unmodified-Since: Mon, 02 Jan 2006 15:04:05 GMT\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 412 Precondition Failed\r\n"))
+
+    // Test: Strong tags from content
+    handler = New(root, Options{StrongETags: true})
+    resp = serve(t, handler, get("/video/clip.mp4"))
+    etag = headerValue(resp, "etag")
+    assert.Equal(t, response.StrongETag([]byte("not really a video")), etag)
+    resp = serve(t, handler, getRange("/video/clip.mp4", "If-Match: " + etag + "\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: If-Range with a strong tag honours the range
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=4-9\r\nIf-Range: " + etag + "\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Range: bytes=4-9\r\nIf-Range: \"stale\"\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestFileServerPrecompressed(t *testing.T) {
+    root, _ := setupRoot(t)
+    script := filepath.Join(root, "site", "app.js")
+    require.NoError(t, os.WriteFile(script, []byte("console.log('plain')"), 0644))
+    require.NoError(t, os.WriteFile(script + ".gz", []byte("pretend gzip bytes"), 0644))
+    require.NoError(t, os.WriteFile(script + ".br", []byte("pretend brotli"), 0644))
+    handler := New(root, Options{Precompressed: true})
+
+    // Test: Client prefers brotli
+    resp := serve(t, handler, getRange("/site/app.js", "Accept-Encoding: gzip, br\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+    assert.Equal(t, "br", headerValue(resp, "content-encoding"))
+    assert.Equal(t, "Accept-Encoding", headerValue(resp, "vary"))
+    assert.Contains(t, headerValue(resp, "content-type"), "javascript")
+    assert.True(t, strings.HasSuffix(headerValue(resp, "etag"), "-br\""))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\npretend brotli"))
+
+    // Test: q-values pick gzip
+    resp = serve(t, handler, getRange("/site/app.js", "Accept-Encoding: gzip, br;q=0.5\r\n"))
+    assert.Equal(t, "gzip", headerValue(resp, "content-encoding"))
+    gzipTag := headerValue(resp, "etag")
+    assert.True(t, strings.HasSuffix(gzipTag, "-gzip\""))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\npretend gzip bytes"))
+
+    // Test: No encoding accepted gets the original but still varies
+    resp = serve(t, handler, get("/site/app.js"))
+    assert.Empty(t, headerValue(resp, "content-encoding"))
+    assert.Equal(t, "Accept-Encoding", headerValue(resp, "vary"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\nconsole.log('plain')"))
+
+    // Test: Ranges apply to the encoded bytes
+    resp = serve(t, handler, getRange("/site/app.js", "Accept-Encoding: gzip\r\nRange: bytes=0-7\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+    assert.Equal(t, "bytes 0-7/18", headerValue(resp, "content-range"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\npretend "))
+
+    // Test: Validators are per encoding
+    resp = serve(t, handler, getRange("/site/app.js", "Accept-Encoding: gzip\r\nIf-None-Match: " + gzipTag + "\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+    resp = serve(t, handler, getRange("/site/app.js", "Accept-Encoding: br\r\nIf-None-Match: " + gzipTag + "\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+    // Test: Files without siblings don't vary
+    resp = serve(t, handler, getRange("/video/clip.mp4", "Accept-Encoding: gzip, br\r\n"))
+    assert.Empty(t, headerValue(resp, "content-encoding"))
+    assert.Empty(t, headerValue(resp, "vary"))
+}
+
+// noSeekFS hides Seek from the files of an fs.FS
+type noSeekFS struct {
+    fs.FS
+}
+
+type noSeekFile struct {
+    fs.File
+}
+
+func (nfs noSeekFS) Open(name string) (fs.File, error) {
+    f, err := nfs.FS.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    return noSeekFile{f}, nil
+}
+
+func TestFileServerFS(t *testing.T) {
+    fsys := fstest.MapFS{
+        "index.html": {Data: []byte("<h1>app</h1>")},
+        "js/app.js": {Data: []byte("console.log('app')")},
+        "notes": {Data: []byte("<html><body>hi</body></html>")},
+    }
+    handler := NewFS(fsys, Options{})
+
+    // Test: Files without a modtime get a content hash and no Last-Modified
+    resp := serve(t, handler, get("/js/app.js"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+    assert.Equal(t, response.StrongETag([]byte("console.log('app')")), headerValue(resp, "etag"))
+    assert.Empty(t, headerValue(resp, "last-modified"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\nconsole.log('app')"))
+
+    // Test: Root serves index.html, content is sniffed
+    resp = serve(t, handler, get("/"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\n<h1>app</h1>"))
+    resp = serve(t, handler, get("/notes"))
+    assert.Equal(t, "text/html; charset=utf-8", headerValue(resp, "content-type"))
+
+    // Test: Ranges through Seek
+    resp = serve(t, handler, getRange("/js/app.js", "Range: bytes=0-6\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\nconsole"))
+
+    // Test: Traversal and missing files
+    resp = serve(t, handler, get("/../../etc/passwd"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+    resp = serve(t, handler, get("/dashboard"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+    // Test: ModTime fills in for missing modification times
+    built := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    handler = NewFS(fsys, Options{ModTime: built})
+    resp = serve(t, handler, get("/js/app.js"))
+    assert.Equal(t, built.Format(http.TimeFormat), headerValue(resp, "last-modified"))
+    assert.Equal(t, response.WeakETag(built, 18), headerValue(resp, "etag"))
+    resp = serve(t, handler, getRange("/js/app.js", "If-Modified-Since: " + built.Format(http.TimeFormat) + "\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+
+    // Test: Files that can't seek are sent whole
+    handler = NewFS(noSeekFS{fsys}, Options{})
+    resp = serve(t, handler, getRange("/notes", "Range: bytes=0-5\r\n"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+    assert.Empty(t, headerValue(resp, "accept-ranges"))
+    assert.Equal(t, "text/html; charset=utf-8", headerValue(resp, "content-type"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\n<html><body>hi</body></html>"))
+
+    // Test: SPA mode falls back to index.html for routes only
+    handler = NewFS(fsys, Options{SPA: true})
+    resp = serve(t, handler, get("/dashboard/settings"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\n<h1>app</h1>"))
+    resp = serve(t, handler, get("/js/missing.js"))
+    assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+    resp = serve(t, handler, get("/js/app.js"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\nconsole.log('app')"))
+}
+
+// countingFS counts the bytes read from the files of an fs.FS
+type countingFS struct {
+    fs.FS
+    read *int
+}
+
+type countingFile struct {
+    fs.File
+    read *int
+}
+
+func (cfs countingFS) Open(name string) (fs.File, error) {
+    f, err := cfs.FS.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    return countingFile{f, cfs.read}, nil
+}
+
+func (cf countingFile) Read(p []byte) (int, error) {
+    n, err := cf.File.Read(p)
+    *cf.read += n
+    return n, err
+}
+
+func TestFileServerETagCache(t *testing.T) {
+    content := bytes.Repeat([]byte("body "), 1000)
+    read := 0
+    handler := NewFS(countingFS{fstest.MapFS{"big.txt": {Data: content}}, &read}, Options{})
+    head := "HEAD /big.txt HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+
+    // Test: Files without a modtime are hashed once
+    resp := serve(t, handler, head)
+    assert.Equal(t, response.StrongETag(content), headerValue(resp, "etag"))
+    assert.GreaterOrEqual(t, read, len(content))
+    read = 0
+    resp = serve(t, handler, head)
+    assert.Equal(t, response.StrongETag(content), headerValue(resp, "etag"))
+    assert.Less(t, read, len(content))
+
+    // Test: A file that changes is hashed again
+    root := t.TempDir()
+    name := filepath.Join(root, "page.html")
+    require.NoError(t, os.WriteFile(name, []byte("<h1>one</h1>"), 0644))
+    handler = New(root, Options{StrongETags: true})
+    resp = serve(t, handler, get("/page.html"))
+    assert.Equal(t, response.StrongETag([]byte("<h1>one</h1>")), headerValue(resp, "etag"))
+    require.NoError(t, os.WriteFile(name, []byte("<h1>two!</h1>"), 0644))
+    resp = serve(t, handler, get("/page.html"))
+    assert.Equal(t, response.StrongETag([]byte("<h1>two!</h1>")), headerValue(resp, "etag"))
+    assert.True(t, strings.HasSuffix(resp, "\r\n\r\n<h1>two!</h1>"))
+}
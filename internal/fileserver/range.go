@@ -0,0 +1,143 @@
+package fileserver
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Past this many ranges the header is ignored and the whole file is sent,
+// a long list of tiny ranges costs far more than it saves
+const maxRanges = 32
+
+var errRangeInvalid = errors.New("invalid range")
+var errRangeUnsatisfiable = errors.New("range not satisfiable")
+
+type byteRange struct {
+    start  int64
+    length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+    return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start + r.length - 1, size)
+}
+
+// parseRange parses a Range header for a representation of size bytes.
+// errRangeInvalid means the header should be ignored, errRangeUnsatisfiable
+// calls for a 416.
+func parseRange(header string, size int64) ([]byteRange, error) {
+    unit, specs, ok := strings.Cut(header, "=")
+    if !ok || strings.TrimSpace(unit) != "bytes" {
+        return nil, errRangeInvalid
+    }
+
+    ranges := []byteRange{}
+    sawSpec := false
+
+    for _, spec := range strings.Split(specs, ",") {
+        spec = strings.TrimSpace(spec)
+        if spec == "" {
+            continue
+        }
+        sawSpec = true
+
+        first, last, ok := strings.Cut(spec, "-")
+        if !ok {
+            return nil, errRangeInvalid
+        }
+        first = strings.TrimSpace(first)
+        last = strings.TrimSpace(last)
+
+        if first == "" {
+            // Suffix range, the last n bytes
+            n, err := parseBytePos(last)
+            if err != nil {
+                return nil, err
+            }
+            if n == 0 || size == 0 {
+                continue
+            }
+            n = min(n, size)
+            ranges = append(ranges, byteRange{start: size - n, length: n})
+            continue
+        }
+
+        start, err := parseBytePos(first)
+        if err != nil {
+            return nil, err
+        }
+        end := size - 1
+        if last != "" {
+            end, err = parseBytePos(last)
+            if err != nil {
+                return nil, err
+            }
+            if end < start {
+                return nil, errRangeInvalid
+            }
+            end = min(end, size - 1)
+        }
+        if start >= size {
+            continue
+        }
+        ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+    }
+
+    if !sawSpec || len(ranges) > maxRanges {
+        return nil, errRangeInvalid
+    }
+    if len(ranges) == 0 {
+        return nil, errRangeUnsatisfiable
+    }
+
+    return ranges, nil
+}
+
+func parseBytePos(s string) (int64, error) {
+    if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+        return 0, errRangeInvalid
+    }
+    n, err := strconv.ParseInt(s, 10, 64)
+    if err != nil {
+        return 0, errRangeInvalid
+    }
+    return n, nil
+}
+
+// multipartLayout describes a multipart/byteranges body, so its length is
+// known before any of it is written
+type multipartLayout struct {
+    boundary string
+    headers  []string
+    closing  string
+}
+
+func newMultipartLayout(ranges []byteRange, contentType string, size int64) (*multipartLayout, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return nil, err
+    }
+
+    m := &multipartLayout{boundary: hex.EncodeToString(b)}
+    for i, r := range ranges {
+        part := fmt.Sprintf("--%v\r\nContent-Type: %v\r\nContent-Range: %v\r\n\r\n", m.boundary, contentType, r.contentRange(size))
+        if i > 0 {
+            part = "\r\n" + part
+        }
+        m.headers = append(m.headers, part)
+    }
+    m.closing = fmt.Sprintf("\r\n--%v--\r\n", m.boundary)
+
+    return m, nil
+}
+
+func (m *multipartLayout) contentLength(ranges []byteRange) int64 {
+    n := int64(len(m.closing))
+    for i, r := range ranges {
+        n += int64(len(m.headers[i])) + r.length
+    }
+    return n
+}
@@ -0,0 +1,201 @@
+package headers
+
+import (
+    "strconv"
+    "strings"
+)
+
+// AcceptItem is one element of an Accept, Accept-Language, Accept-Charset or
+// Accept-Encoding list.
+type AcceptItem struct {
+    // Value is the lowercased media range, language range, charset or coding
+    Value  string
+    // Params holds media range parameters, q excluded
+    Params map[string]string
+    Q      float64
+}
+
+// ParseAccept splits a weighted list into its items in header order. A
+// missing q-value means 1 and an invalid one 0.
+func ParseAccept(header string) []AcceptItem {
+    items := []AcceptItem{}
+
+    for _, element := range strings.Split(header, ",") {
+        parts := strings.Split(element, ";")
+        value := strings.ToLower(strings.TrimSpace(parts[0]))
+        if value == "" {
+            continue
+        }
+
+        item := AcceptItem{Value: value, Params: map[string]string{}, Q: 1}
+        for _, param := range parts[1:] {
+            name, paramValue, _ := strings.Cut(param, "=")
+            name = strings.ToLower(strings.TrimSpace(name))
+            paramValue = strings.Trim(strings.TrimSpace(paramValue), "\"")
+            if name == "" {
+                continue
+            }
+            if name == "q" {
+                q, err := strconv.ParseFloat(paramValue, 64)
+                if err != nil || q < 0 || q > 1 {
+                    q = 0
+                }
+                item.Q = q
+                // Anything after q is an accept extension, not a parameter
+                break
+            }
+            item.Params[name] = paramValue
+        }
+        items = append(items, item)
+    }
+
+    return items
+}
+
+// MediaTypeQuality returns the q-value the most specific media range in
+// items gives mediaType, 0 if none matches. A range with parameters only
+// matches a media type carrying the same ones.
+func MediaTypeQuality(items []AcceptItem, mediaType string) float64 {
+    offer, offerParams := splitMediaType(mediaType)
+    typ, _, _ := strings.Cut(offer, "/")
+    best, bestSpecificity := 0.0, -1
+
+    for _, item := range items {
+        specificity := -1
+        switch item.Value {
+        case offer:
+            specificity = 2
+        case typ + "/*":
+            specificity = 1
+        case "*/*":
+            specificity = 0
+        }
+        if specificity < 0 {
+            continue
+        }
+
+        matches := true
+        for name, value := range item.Params {
+            if !strings.EqualFold(offerParams[name], value) {
+                matches = false
+                break
+            }
+        }
+        if !matches {
+            continue
+        }
+        // Ranges naming parameters are more specific than bare ones
+        specificity = specificity * 100 + len(item.Params)
+
+        if specificity > bestSpecificity {
+            best, bestSpecificity = item.Q, specificity
+        }
+    }
+
+    return best
+}
+
+// LanguageQuality returns the q-value of the longest language range in
+// items that matches tag by prefix, as RFC 4647 basic filtering does.
+// "en" matches "en-US" and "*" matches everything.
+func LanguageQuality(items []AcceptItem, tag string) float64 {
+    tag = strings.ToLower(tag)
+    best, bestLen := 0.0, -1
+
+    for _, item := range items {
+        matchLen := -1
+        switch {
+        case item.Value == "*":
+            matchLen = 0
+        case item.Value == tag || strings.HasPrefix(tag, item.Value + "-"):
+            matchLen = len(item.Value)
+        }
+        if matchLen > bestLen {
+            best, bestLen = item.Q, matchLen
+        }
+    }
+
+    return best
+}
+
+// TokenQuality returns the q-value items give a charset or content-coding,
+// falling back to "*". ok is false when neither is listed.
+func TokenQuality(items []AcceptItem, token string) (q float64, ok bool) {
+    token = strings.ToLower(token)
+    wildcard, hasWildcard := 0.0, false
+
+    for _, item := range items {
+        if item.Value == token {
+            return item.Q, true
+        }
+        if item.Value == "*" {
+            wildcard, hasWildcard = item.Q, true
+        }
+    }
+
+    return wildcard, hasWildcard
+}
+
+// Negotiate picks the offer the request's field, one of Accept,
+// Accept-Language, Accept-Charset or Accept-Encoding, rates highest. Ties
+// go to the earlier offer. ok is false when no offer is acceptable, which
+// calls for a 406. Without the field every offer is acceptable.
+func Negotiate(h Headers, field string, offers []string) (best string, ok bool) {
+    header, present := h[strings.ToLower(field)]
+    if len(offers) == 0 {
+        return "", false
+    }
+    if !present {
+        return offers[0], true
+    }
+
+    items := ParseAccept(header)
+    bestQ := 0.0
+    for _, offer := range offers {
+        var q float64
+        switch strings.ToLower(field) {
+        case "accept":
+            q = MediaTypeQuality(items, offer)
+        case "accept-language":
+            q = LanguageQuality(items, offer)
+        case "accept-encoding":
+            q = EncodingQuality(items, offer)
+        default:
+            q, _ = TokenQuality(items, offer)
+        }
+        if q > bestQ {
+            best, bestQ = offer, q
+        }
+    }
+
+    return best, bestQ > 0
+}
+
+// EncodingQuality is TokenQuality for Accept-Encoding, where identity is
+// acceptable unless the header rules it out.
+func EncodingQuality(items []AcceptItem, coding string) float64 {
+    q, ok := TokenQuality(items, coding)
+    if !ok && strings.EqualFold(coding, "identity") {
+        return 1
+    }
+    return q
+}
+
+// AddVary lists field in the Vary header unless it's already covered.
+func (h Headers) AddVary(field string) {
+    for _, existing := range strings.Split(h.Get("Vary"), ",") {
+        existing = strings.TrimSpace(existing)
+        if existing == "*" || strings.EqualFold(existing, field) {
+            return
+        }
+    }
+    h.Set("Vary", field)
+}
+
+func splitMediaType(mediaType string) (string, map[string]string) {
+    items := ParseAccept(mediaType)
+    if len(items) == 0 {
+        return "", nil
+    }
+    return items[0].Value, items[0].Params
+}
@@ -7,6 +7,8 @@ import (
 )
 
 const crlf = "\r\n"
+// Separates the lines of a multi-line field, it can't appear in a valid value
+const lineSeparator = "\n"
 const fieldNameRegEx = "^[A-Za-z0-9,#$%&'*+.^_`|~-]+$"
 
 type Headers map[string]string
@@ -15,19 +17,49 @@ func NewHeaders() Headers {
     return make(Headers)
 }
 
+// Get returns the field value, with the lines stored by Add combined into
+// one comma separated list.
 func (h Headers) Get(key string) string {
     key = strings.ToLower(key)
-    return h[key]
+    return strings.ReplaceAll(h[key], lineSeparator, ", ")
 }
 
+// Set appends value to the field as a comma separated list, on its last
+// line if Add made several. Set-Cookie values can't be combined, they
+// always get a line of their own.
 func (h Headers) Set(key string, value string) {
     key = strings.ToLower(key)
+    if key == "set-cookie" {
+        h.Add(key, value)
+        return
+    }
     if h[key] != "" {
         value = h[key] + ", " + value
     }
     h[key] = value
 }
 
+// Add appends another line for key instead of folding value into a comma
+// separated list. Fields like Set-Cookie can't be combined and must be
+// written one per line.
+func (h Headers) Add(key string, value string) {
+    key = strings.ToLower(key)
+    if _, ok := h[key]; ok {
+        value = h[key] + lineSeparator + value
+    }
+    h[key] = value
+}
+
+// Values returns each line stored for key by Add, or the single value set
+// any other way.
+func (h Headers) Values(key string) []string {
+    value, ok := h[strings.ToLower(key)]
+    if !ok {
+        return nil
+    }
+    return strings.Split(value, lineSeparator)
+}
+
 func (h Headers) Replace(key string, value string) {
     key = strings.ToLower(key)
     h[key] = value
@@ -38,6 +70,13 @@ func (h Headers) Remove(key string) {
     delete(h, key)
 }
 
+// ValidFieldValue reports whether v is free of CR, LF and NUL, which
+// RFC 9110 section 5.5 forbids in field values. A bare LF would otherwise
+// split into separate lines for Values.
+func ValidFieldValue(v string) bool {
+    return !strings.ContainsAny(v, "\r\n\x00")
+}
+
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
     tmpSplice := strings.Split(string(data), crlf)
 
@@ -73,8 +112,22 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
     }
 
     fieldValue = strings.TrimSpace(fieldValue)
+    if !ValidFieldValue(fieldValue) {
+        return 0, false, fmt.Errorf("field value contains CR, LF or NUL - %q", headerString)
+    }
 
-    h.Set(fieldName, fieldValue)
+    switch strings.ToLower(fieldName) {
+    case "cookie":
+        // Cookie lines are joined with "; ", not ", "
+        if existing := h.Get(fieldName); existing != "" {
+            fieldValue = existing + "; " + fieldValue
+        }
+        h.Replace(fieldName, fieldValue)
+    case "set-cookie":
+        h.Add(fieldName, fieldValue)
+    default:
+        h.Set(fieldName, fieldValue)
+    }
 
     return
 }
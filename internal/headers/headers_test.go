@@ -94,4 +94,103 @@ func TestHeaderParse(t *testing.T) {
     require.Equal(t, "lane-loves-go, prime-loves-zig", headers["set-person"])
     assert.Equal(t, 29, n)
     assert.False(t, done)
+
+    // Test: Set-Cookie lines are kept apart
+    headers = NewHeaders()
+    data = []byte("Set-Cookie: a=1; Path=/\r\nSet-Cookie: b=2\r\n\r\n")
+    n, _, err = headers.Parse(data)
+    require.NoError(t, err)
+    _, _, err = headers.Parse(data[n:])
+    require.NoError(t, err)
+    assert.Equal(t, []string{"a=1; Path=/", "b=2"}, headers.Values("Set-Cookie"))
+
+    // Test: Mixing Add and Set keeps Get, Values and the lines in step
+    headers = NewHeaders()
+    headers.Add("Vary", "Accept")
+    headers.Add("Vary", "Origin")
+    headers.Set("Vary", "Accept-Encoding")
+    assert.Equal(t, []string{"Accept", "Origin, Accept-Encoding"}, headers.Values("Vary"))
+    assert.Equal(t, "Accept, Origin, Accept-Encoding", headers.Get("Vary"))
+    headers.AddVary("Origin")
+    assert.Equal(t, "Accept, Origin, Accept-Encoding", headers.Get("Vary"))
+
+    // Test: Set never folds Set-Cookie values together
+    headers = NewHeaders()
+    headers.Add("Set-Cookie", "a=1")
+    headers.Set("Set-Cookie", "b=2")
+    headers.Set("Set-Cookie", "c=3")
+    assert.Equal(t, []string{"a=1", "b=2", "c=3"}, headers.Values("Set-Cookie"))
+
+    // Test: Cookie lines are joined with semicolons
+    headers = NewHeaders()
+    data = []byte("Cookie: a=1\r\nCookie: b=2\r\n\r\n")
+    n, _, err = headers.Parse(data)
+    require.NoError(t, err)
+    _, _, err = headers.Parse(data[n:])
+    require.NoError(t, err)
+    assert.Equal(t, "a=1; b=2", headers.Get("Cookie"))
+
+    // Test: CR, LF and NUL inside a value are rejected, a bare LF would
+    // smuggle in extra lines
+    for _, value := range []string{"a=1\nb=2", "a=1\rb=2", "a=1\x00b=2"} {
+        headers = NewHeaders()
+        data = []byte("Cookie: " + value + "\r\n\r\n")
+        n, done, err = headers.Parse(data)
+        require.Error(t, err, "%q", value)
+        assert.Equal(t, 0, n)
+        assert.False(t, done)
+        assert.Empty(t, headers.Values("Cookie"))
+    }
+}
+
+func TestAcceptNegotiation(t *testing.T) {
+    // Test: Parsing q-values and parameters
+    items := ParseAccept("text/html;level=1, application/json;q=0.5, */*;q=bogus")
+    require.Len(t, items, 3)
+    assert.Equal(t, AcceptItem{"text/html", map[string]string{"level": "1"}, 1}, items[0])
+    assert.Equal(t, 0.5, items[1].Q)
+    assert.Equal(t, 0.0, items[2].Q)
+
+    // Test: Most specific media range wins
+    items = ParseAccept("text/*;q=0.3, text/html;q=0.7, text/html;level=1, */*;q=0.5")
+    assert.Equal(t, 1.0, MediaTypeQuality(items, "text/html;level=1"))
+    assert.Equal(t, 0.7, MediaTypeQuality(items, "text/html"))
+    assert.Equal(t, 0.3, MediaTypeQuality(items, "text/plain"))
+    assert.Equal(t, 0.5, MediaTypeQuality(items, "image/png"))
+
+    h := NewHeaders()
+    h.Set("Accept", "application/xml;q=0.9, application/json")
+    h.Set("Accept-Language", "de-CH, de;q=0.9, en;q=0.5")
+    h.Set("Accept-Charset", "utf-8, iso-8859-1;q=0.5")
+    h.Set("Accept-Encoding", "gzip, identity;q=0")
+
+    // Test: Negotiate per field
+    best, ok := Negotiate(h, "Accept", []string{"text/html", "application/xml", "application/json"})
+    assert.True(t, ok)
+    assert.Equal(t, "application/json", best)
+    best, _ = Negotiate(h, "Accept-Language", []string{"en-GB", "de-AT"})
+    assert.Equal(t, "de-AT", best)
+    best, _ = Negotiate(h, "Accept-Charset", []string{"iso-8859-1", "utf-8"})
+    assert.Equal(t, "utf-8", best)
+
+    // Test: Nothing acceptable
+    _, ok = Negotiate(h, "Accept", []string{"text/html"})
+    assert.False(t, ok)
+    _, ok = Negotiate(h, "Accept-Encoding", []string{"identity"})
+    assert.False(t, ok)
+
+    // Test: Missing field accepts the first offer, identity is implied
+    best, ok = Negotiate(NewHeaders(), "Accept", []string{"text/html", "application/json"})
+    assert.True(t, ok)
+    assert.Equal(t, "text/html", best)
+    h.Replace("Accept-Encoding", "br")
+    best, _ = Negotiate(h, "Accept-Encoding", []string{"gzip", "identity"})
+    assert.Equal(t, "identity", best)
+
+    // Test: Vary maintenance
+    h = NewHeaders()
+    h.AddVary("Accept")
+    h.AddVary("accept")
+    h.AddVary("Accept-Encoding")
+    assert.Equal(t, "Accept, Accept-Encoding", h.Get("Vary"))
 }
@@ -0,0 +1,255 @@
+package http2
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+)
+
+const frameHeaderLen = 9
+
+const (
+    FrameData         uint8 = 0x0
+    FrameHeaders      uint8 = 0x1
+    FramePriority     uint8 = 0x2
+    FrameRSTStream    uint8 = 0x3
+    FrameSettings     uint8 = 0x4
+    FramePushPromise  uint8 = 0x5
+    FramePing         uint8 = 0x6
+    FrameGoAway       uint8 = 0x7
+    FrameWindowUpdate uint8 = 0x8
+    FrameContinuation uint8 = 0x9
+)
+
+const (
+    FlagEndStream  uint8 = 0x1
+    FlagAck        uint8 = 0x1
+    FlagEndHeaders uint8 = 0x4
+    FlagPadded     uint8 = 0x8
+    FlagPriority   uint8 = 0x20
+)
+
+const (
+    SettingHeaderTableSize      uint16 = 0x1
+    SettingEnablePush           uint16 = 0x2
+    SettingMaxConcurrentStreams uint16 = 0x3
+    SettingInitialWindowSize    uint16 = 0x4
+    SettingMaxFrameSize         uint16 = 0x5
+    SettingMaxHeaderListSize    uint16 = 0x6
+)
+
+const (
+    ErrCodeNo                 uint32 = 0x0
+    ErrCodeProtocol           uint32 = 0x1
+    ErrCodeInternal           uint32 = 0x2
+    ErrCodeFlowControl        uint32 = 0x3
+    ErrCodeStreamClosed       uint32 = 0x5
+    ErrCodeFrameSize          uint32 = 0x6
+    ErrCodeRefusedStream      uint32 = 0x7
+    ErrCodeCancel             uint32 = 0x8
+    ErrCodeCompression        uint32 = 0x9
+    ErrCodeEnhanceYourCalm    uint32 = 0xb
+)
+
+const (
+    defaultMaxFrameSize = 16384
+    maxFrameSizeLimit = 1 << 24 - 1
+    defaultWindowSize = 65535
+    maxWindowSize = 1 << 31 - 1
+)
+
+type Frame struct {
+    Type     uint8
+    Flags    uint8
+    StreamID uint32
+    Payload  []byte
+}
+
+func (f Frame) Has(flag uint8) bool {
+    return f.Flags & flag != 0
+}
+
+type Setting struct {
+    ID    uint16
+    Value uint32
+}
+
+// ReadFrame reads one frame, rejecting payloads larger than maxSize.
+func ReadFrame(r io.Reader, maxSize uint32) (Frame, error) {
+    hdr := make([]byte, frameHeaderLen)
+    if _, err := io.ReadFull(r, hdr); err != nil {
+        return Frame{}, err
+    }
+
+    length := uint32(hdr[0]) << 16 | uint32(hdr[1]) << 8 | uint32(hdr[2])
+    if length > maxSize {
+        return Frame{}, fmt.Errorf("frame of %d bytes exceeds max frame size %d", length, maxSize)
+    }
+
+    f := Frame{
+        Type: hdr[3],
+        Flags: hdr[4],
+        StreamID: binary.BigEndian.Uint32(hdr[5:]) & maxWindowSize,
+        Payload: make([]byte, length),
+    }
+    if _, err := io.ReadFull(r, f.Payload); err != nil {
+        return Frame{}, err
+    }
+
+    return f, nil
+}
+
+func WriteFrame(w io.Writer, f Frame) error {
+    b := make([]byte, frameHeaderLen, frameHeaderLen + len(f.Payload))
+    length := len(f.Payload)
+    b[0] = byte(length >> 16)
+    b[1] = byte(length >> 8)
+    b[2] = byte(length)
+    b[3] = f.Type
+    b[4] = f.Flags
+    binary.BigEndian.PutUint32(b[5:], f.StreamID & maxWindowSize)
+    b = append(b, f.Payload...)
+
+    _, err := w.Write(b)
+
+    return err
+}
+
+// DataPayload strips the padding from a DATA frame.
+func (f Frame) DataPayload() ([]byte, error) {
+    return stripPadding(f)
+}
+
+// HeaderBlock returns the header block fragment of a HEADERS frame without
+// padding and priority fields.
+func (f Frame) HeaderBlock() ([]byte, error) {
+    p, err := stripPadding(f)
+    if err != nil {
+        return nil, err
+    }
+    if f.Has(FlagPriority) {
+        if len(p) < 5 {
+            return nil, errors.New("HEADERS frame too short for priority fields")
+        }
+        p = p[5:]
+    }
+    return p, nil
+}
+
+func stripPadding(f Frame) ([]byte, error) {
+    p := f.Payload
+    if !f.Has(FlagPadded) {
+        return p, nil
+    }
+    if len(p) < 1 {
+        return nil, errors.New("padded frame is missing pad length")
+    }
+    padLen := int(p[0])
+    if padLen >= len(p) {
+        return nil, errors.New("frame padding exceeds payload")
+    }
+    return p[1:len(p) - padLen], nil
+}
+
+func ParseSettings(p []byte) ([]Setting, error) {
+    if len(p) % 6 != 0 {
+        return nil, errors.New("SETTINGS payload is not a multiple of 6")
+    }
+    settings := []Setting{}
+    for i := 0; i < len(p); i += 6 {
+        settings = append(settings, Setting{
+            ID: binary.BigEndian.Uint16(p[i:]),
+            Value: binary.BigEndian.Uint32(p[i + 2:]),
+        })
+    }
+    return settings, nil
+}
+
+func SettingsFrame(settings []Setting) Frame {
+    p := make([]byte, 0, len(settings) * 6)
+    for _, s := range settings {
+        p = binary.BigEndian.AppendUint16(p, s.ID)
+        p = binary.BigEndian.AppendUint32(p, s.Value)
+    }
+    return Frame{Type: FrameSettings, Payload: p}
+}
+
+func SettingsAckFrame() Frame {
+    return Frame{Type: FrameSettings, Flags: FlagAck}
+}
+
+func PingFrame(data [8]byte, ack bool) Frame {
+    f := Frame{Type: FramePing, Payload: data[:]}
+    if ack {
+        f.Flags = FlagAck
+    }
+    return f
+}
+
+func WindowUpdateFrame(streamID uint32, increment uint32) Frame {
+    return Frame{
+        Type: FrameWindowUpdate,
+        StreamID: streamID,
+        Payload: binary.BigEndian.AppendUint32(nil, increment & maxWindowSize),
+    }
+}
+
+func ParseWindowUpdate(p []byte) (uint32, error) {
+    if len(p) != 4 {
+        return 0, errors.New("WINDOW_UPDATE payload must be 4 bytes")
+    }
+    return binary.BigEndian.Uint32(p) & maxWindowSize, nil
+}
+
+func RSTStreamFrame(streamID uint32, code uint32) Frame {
+    return Frame{
+        Type: FrameRSTStream,
+        StreamID: streamID,
+        Payload: binary.BigEndian.AppendUint32(nil, code),
+    }
+}
+
+func ParseRSTStream(p []byte) (uint32, error) {
+    if len(p) != 4 {
+        return 0, errors.New("RST_STREAM payload must be 4 bytes")
+    }
+    return binary.BigEndian.Uint32(p), nil
+}
+
+func GoAwayFrame(lastStreamID uint32, code uint32, debug string) Frame {
+    p := binary.BigEndian.AppendUint32(nil, lastStreamID & maxWindowSize)
+    p = binary.BigEndian.AppendUint32(p, code)
+    p = append(p, debug...)
+    return Frame{Type: FrameGoAway, Payload: p}
+}
+
+func ParseGoAway(p []byte) (lastStreamID uint32, code uint32, err error) {
+    if len(p) < 8 {
+        return 0, 0, errors.New("GOAWAY payload too short")
+    }
+    return binary.BigEndian.Uint32(p) & maxWindowSize, binary.BigEndian.Uint32(p[4:]), nil
+}
+
+// HeaderFrames splits a header block into a HEADERS frame followed by as
+// many CONTINUATION frames as maxSize requires.
+func HeaderFrames(streamID uint32, block []byte, endStream bool, maxSize int) []Frame {
+    frames := []Frame{}
+    typ := FrameHeaders
+
+    for {
+        n := min(len(block), maxSize)
+        f := Frame{Type: typ, StreamID: streamID, Payload: block[:n]}
+        if typ == FrameHeaders && endStream {
+            f.Flags |= FlagEndStream
+        }
+        block = block[n:]
+        if len(block) == 0 {
+            f.Flags |= FlagEndHeaders
+            frames = append(frames, f)
+            return frames
+        }
+        frames = append(frames, f)
+        typ = FrameContinuation
+    }
+}
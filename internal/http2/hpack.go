@@ -0,0 +1,362 @@
+package http2
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+const defaultHeaderTableSize = 4096
+
+// HeaderField is a single decoded header, names are always lowercase.
+type HeaderField struct {
+    Name  string
+    Value string
+}
+
+func (hf HeaderField) size() int {
+    return len(hf.Name) + len(hf.Value) + 32
+}
+
+// Static table from RFC 7541 Appendix A, index 1 is staticTable[0].
+var staticTable = []HeaderField{
+    {":authority", ""},
+    {":method", "GET"},
+    {":method", "POST"},
+    {":path", "/"},
+    {":path", "/index.html"},
+    {":scheme", "http"},
+    {":scheme", "https"},
+    {":status", "200"},
+    {":status", "204"},
+    {":status", "206"},
+    {":status", "304"},
+    {":status", "400"},
+    {":status", "404"},
+    {":status", "500"},
+    {"accept-charset", ""},
+    {"accept-encoding", "gzip, deflate"},
+    {"accept-language", ""},
+    {"accept-ranges", ""},
+    {"accept", ""},
+    {"access-control-allow-origin", ""},
+    {"age", ""},
+    {"allow", ""},
+    {"authorization", ""},
+    {"cache-control", ""},
+    {"content-disposition", ""},
+    {"content-encoding", ""},
+    {"content-language", ""},
+    {"content-length", ""},
+    {"content-location", ""},
+    {"content-range", ""},
+    {"content-type", ""},
+    {"cookie", ""},
+    {"date", ""},
+    {"etag", ""},
+    {"expect", ""},
+    {"expires", ""},
+    {"from", ""},
+    {"host", ""},
+    {"if-match", ""},
+    {"if-modified-since", ""},
+    {"if-none-match", ""},
+    {"if-range", ""},
+    {"if-unmodified-since", ""},
+    {"last-modified", ""},
+    {"link", ""},
+    {"location", ""},
+    {"max-forwards", ""},
+    {"proxy-authenticate", ""},
+    {"proxy-authorization", ""},
+    {"range", ""},
+    {"referer", ""},
+    {"refresh", ""},
+    {"retry-after", ""},
+    {"server", ""},
+    {"set-cookie", ""},
+    {"strict-transport-security", ""},
+    {"transfer-encoding", ""},
+    {"user-agent", ""},
+    {"vary", ""},
+    {"via", ""},
+    {"www-authenticate", ""},
+}
+
+// Decoder decodes HPACK header blocks. It keeps the dynamic table between
+// blocks, so one Decoder must be used per connection.
+type Decoder struct {
+    dynamic    []HeaderField // newest first
+    size       int
+    maxSize    int
+    maxAllowed int
+    // maxListSize bounds the decoded header list, 0 means no limit
+    maxListSize int
+}
+
+// ErrHeaderListTooLarge is returned for a block whose decoded header list,
+// counted as in SETTINGS_MAX_HEADER_LIST_SIZE, is past the Decoder's limit.
+// The block is still decoded in full, so the dynamic table stays usable.
+var ErrHeaderListTooLarge = errors.New("hpack: header list too large")
+
+func NewDecoder(maxTableSize int) *Decoder {
+    return &Decoder{
+        maxSize: maxTableSize,
+        maxAllowed: maxTableSize,
+    }
+}
+
+// SetMaxHeaderListSize limits the size of the header lists Decode returns
+func (d *Decoder) SetMaxHeaderListSize(n int) {
+    d.maxListSize = n
+}
+
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+    fields := []HeaderField{}
+    sawField := false
+    // Fields past the limit are dropped, a few bytes of block can index
+    // a large table entry over and over
+    listSize := 0
+    emit := func(hf HeaderField) {
+        listSize += hf.size()
+        if d.maxListSize == 0 || listSize <= d.maxListSize {
+            fields = append(fields, hf)
+        }
+        sawField = true
+    }
+
+    for len(block) > 0 {
+        b := block[0]
+        switch {
+        case b&0x80 != 0:
+            // Indexed header field
+            idx, n, err := decodeInt(block, 7)
+            if err != nil {
+                return nil, err
+            }
+            block = block[n:]
+            hf, err := d.at(idx)
+            if err != nil {
+                return nil, err
+            }
+            emit(hf)
+        case b&0xc0 == 0x40:
+            // Literal with incremental indexing
+            hf, n, err := d.decodeLiteral(block, 6)
+            if err != nil {
+                return nil, err
+            }
+            block = block[n:]
+            d.add(hf)
+            emit(hf)
+        case b&0xe0 == 0x20:
+            // Dynamic table size update, only allowed at the start of a block
+            if sawField {
+                return nil, errors.New("hpack: table size update after header field")
+            }
+            size, n, err := decodeInt(block, 5)
+            if err != nil {
+                return nil, err
+            }
+            block = block[n:]
+            if size > uint64(d.maxAllowed) {
+                return nil, fmt.Errorf("hpack: table size update %d exceeds limit %d", size, d.maxAllowed)
+            }
+            d.maxSize = int(size)
+            d.evict()
+        default:
+            // Literal without indexing or never indexed
+            hf, n, err := d.decodeLiteral(block, 4)
+            if err != nil {
+                return nil, err
+            }
+            block = block[n:]
+            emit(hf)
+        }
+    }
+
+    if d.maxListSize > 0 && listSize > d.maxListSize {
+        return nil, ErrHeaderListTooLarge
+    }
+    return fields, nil
+}
+
+func (d *Decoder) at(idx uint64) (HeaderField, error) {
+    if idx == 0 {
+        return HeaderField{}, errors.New("hpack: index 0 is invalid")
+    }
+    if idx <= uint64(len(staticTable)) {
+        return staticTable[idx - 1], nil
+    }
+    idx -= uint64(len(staticTable)) + 1
+    if idx >= uint64(len(d.dynamic)) {
+        return HeaderField{}, fmt.Errorf("hpack: index %d out of range", idx + uint64(len(staticTable)) + 1)
+    }
+    return d.dynamic[idx], nil
+}
+
+func (d *Decoder) decodeLiteral(block []byte, prefix uint8) (hf HeaderField, n int, err error) {
+    idx, n, err := decodeInt(block, prefix)
+    if err != nil {
+        return HeaderField{}, 0, err
+    }
+
+    if idx == 0 {
+        name, m, err := decodeString(block[n:])
+        if err != nil {
+            return HeaderField{}, 0, err
+        }
+        n += m
+        hf.Name = name
+    } else {
+        indexed, err := d.at(idx)
+        if err != nil {
+            return HeaderField{}, 0, err
+        }
+        hf.Name = indexed.Name
+    }
+
+    value, m, err := decodeString(block[n:])
+    if err != nil {
+        return HeaderField{}, 0, err
+    }
+    n += m
+    hf.Value = value
+
+    return hf, n, nil
+}
+
+func (d *Decoder) add(hf HeaderField) {
+    if hf.size() > d.maxSize {
+        d.dynamic = d.dynamic[:0]
+        d.size = 0
+        return
+    }
+    d.dynamic = append([]HeaderField{hf}, d.dynamic...)
+    d.size += hf.size()
+    d.evict()
+}
+
+func (d *Decoder) evict() {
+    for d.size > d.maxSize && len(d.dynamic) > 0 {
+        last := d.dynamic[len(d.dynamic) - 1]
+        d.dynamic = d.dynamic[:len(d.dynamic) - 1]
+        d.size -= last.size()
+    }
+}
+
+// Encoder encodes header blocks without using the dynamic table, which
+// keeps it stateless and always compatible with the peer's table size.
+type Encoder struct{}
+
+func (e *Encoder) Encode(fields []HeaderField) []byte {
+    b := []byte{}
+
+    for _, hf := range fields {
+        nameIdx := 0
+        exactIdx := 0
+        for i, s := range staticTable {
+            if s.Name != hf.Name {
+                continue
+            }
+            if nameIdx == 0 {
+                nameIdx = i + 1
+            }
+            if s.Value == hf.Value {
+                exactIdx = i + 1
+                break
+            }
+        }
+
+        if exactIdx != 0 {
+            b = appendInt(b, 0x80, 7, uint64(exactIdx))
+            continue
+        }
+
+        // Literal without indexing
+        b = appendInt(b, 0x00, 4, uint64(nameIdx))
+        if nameIdx == 0 {
+            b = appendString(b, strings.ToLower(hf.Name))
+        }
+        b = appendString(b, hf.Value)
+    }
+
+    return b
+}
+
+func decodeInt(b []byte, prefix uint8) (v uint64, n int, err error) {
+    if len(b) == 0 {
+        return 0, 0, errors.New("hpack: truncated integer")
+    }
+    mask := uint64(1) << prefix - 1
+    v = uint64(b[0]) & mask
+    n = 1
+    if v < mask {
+        return v, n, nil
+    }
+
+    var shift uint
+    for {
+        if n >= len(b) {
+            return 0, 0, errors.New("hpack: truncated integer")
+        }
+        c := b[n]
+        n++
+        v += uint64(c & 0x7f) << shift
+        if c & 0x80 == 0 {
+            break
+        }
+        shift += 7
+        if shift > 56 {
+            return 0, 0, errors.New("hpack: integer overflow")
+        }
+    }
+
+    return v, n, nil
+}
+
+func appendInt(b []byte, first byte, prefix uint8, v uint64) []byte {
+    mask := uint64(1) << prefix - 1
+    if v < mask {
+        return append(b, first | byte(v))
+    }
+    b = append(b, first | byte(mask))
+    v -= mask
+    for v >= 0x80 {
+        b = append(b, byte(v & 0x7f) | 0x80)
+        v >>= 7
+    }
+    return append(b, byte(v))
+}
+
+func decodeString(b []byte) (s string, n int, err error) {
+    if len(b) == 0 {
+        return "", 0, errors.New("hpack: truncated string")
+    }
+    huffman := b[0] & 0x80 != 0
+    length, n, err := decodeInt(b, 7)
+    if err != nil {
+        return "", 0, err
+    }
+    if uint64(len(b) - n) < length {
+        return "", 0, errors.New("hpack: truncated string")
+    }
+    raw := b[n:n + int(length)]
+    n += int(length)
+
+    if !huffman {
+        return string(raw), n, nil
+    }
+
+    s, err = huffmanDecode(raw)
+    if err != nil {
+        return "", 0, err
+    }
+
+    return s, n, nil
+}
+
+func appendString(b []byte, s string) []byte {
+    b = appendInt(b, 0x00, 7, uint64(len(s)))
+    return append(b, s...)
+}
@@ -0,0 +1,474 @@
+package http2
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "io"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+    b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+    require.NoError(t, err)
+    return b
+}
+
+func TestHpackDecode(t *testing.T) {
+    // Test: RFC 7541 C.4, requests with huffman coding sharing a dynamic table
+    d := NewDecoder(defaultHeaderTableSize)
+    fields, err := d.Decode(decodeHex(t, "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff"))
+    require.NoError(t, err)
+    assert.Equal(t, []HeaderField{
+        {":method", "GET"},
+        {":scheme", "http"},
+        {":path", "/"},
+        {":authority", "www.example.com"},
+    }, fields)
+
+    fields, err = d.Decode(decodeHex(t, "8286 84be 5886 a8eb 1064 9cbf"))
+    require.NoError(t, err)
+    assert.Equal(t, []HeaderField{
+        {":method", "GET"},
+        {":scheme", "http"},
+        {":path", "/"},
+        {":authority", "www.example.com"},
+        {"cache-control", "no-cache"},
+    }, fields)
+
+    fields, err = d.Decode(decodeHex(t, "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf"))
+    require.NoError(t, err)
+    assert.Equal(t, []HeaderField{
+        {":method", "GET"},
+        {":scheme", "https"},
+        {":path", "/index.html"},
+        {":authority", "www.example.com"},
+        {"custom-key", "custom-value"},
+    }, fields)
+
+    // Test: Index out of range
+    d = NewDecoder(defaultHeaderTableSize)
+    _, err = d.Decode([]byte{0xbe})
+    require.Error(t, err)
+
+    // Test: Table size update above the limit
+    _, err = d.Decode(appendInt(nil, 0x20, 5, 8192))
+    require.Error(t, err)
+
+    // Test: Header lists past the limit are refused, yet the block is
+    // decoded in full and the dynamic table stays in sync
+    d = NewDecoder(defaultHeaderTableSize)
+    d.SetMaxHeaderListSize(200)
+    block := appendInt(nil, 0x40, 6, 0)
+    block = appendInt(block, 0, 7, 5)
+    block = append(block, "x-big"...)
+    block = appendInt(block, 0, 7, 60)
+    block = append(block, strings.Repeat("a", 60)...)
+    for i := 0; i < 10; i++ {
+        block = appendInt(block, 0x80, 7, uint64(len(staticTable) + 1))
+    }
+    _, err = d.Decode(block)
+    require.ErrorIs(t, err, ErrHeaderListTooLarge)
+    fields, err = d.Decode(appendInt(nil, 0x80, 7, uint64(len(staticTable) + 1)))
+    require.NoError(t, err)
+    assert.Equal(t, []HeaderField{{"x-big", strings.Repeat("a", 60)}}, fields)
+
+    // Test: Encoder output decodes back
+    fields = []HeaderField{{":status", "200"}, {"content-type", "text/plain"}, {"x-thing", "kakius"}}
+    block = (&Encoder{}).Encode(fields)
+    decoded, err := NewDecoder(defaultHeaderTableSize).Decode(block)
+    require.NoError(t, err)
+    assert.Equal(t, fields, decoded)
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+    // Test: Frame survives write and read
+    buf := &bytes.Buffer{}
+    require.NoError(t, WriteFrame(buf, WindowUpdateFrame(3, 1000)))
+    f, err := ReadFrame(buf, defaultMaxFrameSize)
+    require.NoError(t, err)
+    assert.Equal(t, FrameWindowUpdate, f.Type)
+    assert.Equal(t, uint32(3), f.StreamID)
+    increment, err := ParseWindowUpdate(f.Payload)
+    require.NoError(t, err)
+    assert.Equal(t, uint32(1000), increment)
+
+    // Test: Oversized frame
+    require.NoError(t, WriteFrame(buf, Frame{Type: FrameData, StreamID: 1, Payload: make([]byte, 100)}))
+    _, err = ReadFrame(buf, 50)
+    require.Error(t, err)
+
+    // Test: Header block split into CONTINUATION frames
+    frames := HeaderFrames(1, make([]byte, 25), true, 10)
+    require.Len(t, frames, 3)
+    assert.Equal(t, FrameHeaders, frames[0].Type)
+    assert.True(t, frames[0].Has(FlagEndStream))
+    assert.False(t, frames[0].Has(FlagEndHeaders))
+    assert.Equal(t, FrameContinuation, frames[2].Type)
+    assert.True(t, frames[2].Has(FlagEndHeaders))
+}
+
+func TestRequestFromFields(t *testing.T) {
+    // Test: Pseudo-headers and cookies become the request
+    req, err := requestFromFields([]HeaderField{
+        {":method", "GET"}, {":scheme", "http"}, {":path", "/a?b=c"}, {":authority", "localhost"},
+        {"cookie", "a=1"}, {"cookie", "b=2"},
+    })
+    require.NoError(t, err)
+    assert.Equal(t, "GET", req.RequestLine.Method)
+    assert.Equal(t, "/a", req.URL.Path)
+    assert.Equal(t, "a=1; b=2", req.Headers.Get("Cookie"))
+
+    // Test: CR, LF and NUL in a value make the request malformed
+    for _, value := range []string{"a=1\nb=2", "a=1\rb=2", "a=1\x00b=2"} {
+        _, err = requestFromFields([]HeaderField{{":method", "GET"}, {":path", "/"}, {"cookie", value}})
+        require.Error(t, err, "%q", value)
+    }
+}
+
+func TestServePriorKnowledge(t *testing.T) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    require.NoError(t, err)
+    defer listener.Close()
+
+    srv := &Server{
+        Handler: func(w *response.Writer, req *request.Request) {
+            body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body))
+            w.WriteHttpMessage(200, response.GetDefaultHeaders(0), body)
+        },
+        Context: context.Background(),
+    }
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer conn.Close()
+                srv.ServeConn(conn, conn)
+            }()
+        }
+    }()
+
+    protocols := &http.Protocols{}
+    protocols.SetUnencryptedHTTP2(true)
+    client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+    // Test: Simple GET
+    resp, err := client.Get("http://" + listener.Addr().String() + "/coffee")
+    require.NoError(t, err)
+    body, err := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    require.NoError(t, err)
+    assert.Equal(t, 2, resp.ProtoMajor)
+    assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+    assert.Equal(t, "GET /coffee ", string(body))
+
+    // Test: POST body larger than the initial window
+    payload := strings.Repeat("a", 100000)
+    resp, err = client.Post("http://" + listener.Addr().String() + "/submit", "text/plain", strings.NewReader(payload))
+    require.NoError(t, err)
+    body, err = io.ReadAll(resp.Body)
+    resp.Body.Close()
+    require.NoError(t, err)
+    assert.Equal(t, "POST /submit " + payload, string(body))
+}
+
+func TestServeBodyLimit(t *testing.T) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    require.NoError(t, err)
+    defer listener.Close()
+
+    srv := &Server{
+        Handler: func(w *response.Writer, req *request.Request) {
+            w.WriteHttpMessage(200, response.GetDefaultHeaders(0), []byte(strconv.Itoa(len(req.Body))))
+        },
+        Context: context.Background(),
+        MaxBodySize: 1000,
+    }
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer conn.Close()
+                srv.ServeConn(conn, conn)
+            }()
+        }
+    }()
+
+    protocols := &http.Protocols{}
+    protocols.SetUnencryptedHTTP2(true)
+    client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+    url := "http://" + listener.Addr().String() + "/upload"
+
+    // Test: Bodies within the limit reach the handler
+    resp, err := client.Post(url, "text/plain", strings.NewReader(strings.Repeat("a", 1000)))
+    require.NoError(t, err)
+    body, err := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    require.NoError(t, err)
+    assert.Equal(t, 200, resp.StatusCode)
+    assert.Equal(t, "1000", string(body))
+
+    // Test: An announced length past the limit is refused up front
+    resp, err = client.Post(url, "text/plain", strings.NewReader(strings.Repeat("a", 100000)))
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, 413, resp.StatusCode)
+    assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+    // Test: So is a body that grows past it without a length
+    resp, err = client.Post(url, "text/plain", io.MultiReader(strings.NewReader(strings.Repeat("a", 100000))))
+    require.NoError(t, err)
+    resp.Body.Close()
+    assert.Equal(t, 413, resp.StatusCode)
+
+    // Test: The connection is still usable
+    resp, err = client.Post(url, "text/plain", strings.NewReader("ok"))
+    require.NoError(t, err)
+    body, err = io.ReadAll(resp.Body)
+    resp.Body.Close()
+    require.NoError(t, err)
+    assert.Equal(t, "2", string(body))
+}
+
+func TestFlowControl(t *testing.T) {
+    serverSide, clientSide := net.Pipe()
+    defer clientSide.Close()
+    frames := make(chan Frame, 16)
+    go func() {
+        for {
+            f, err := ReadFrame(clientSide, defaultMaxFrameSize)
+            if err != nil {
+                close(frames)
+                return
+            }
+            frames <- f
+        }
+    }()
+
+    srv := &Server{Handler: func(w *response.Writer, req *request.Request) {}, Context: context.Background()}
+    sc := srv.newConn(serverSide, serverSide)
+    defer sc.shutdown()
+    req, err := requestFromFields([]HeaderField{{":method", "POST"}, {":path", "/"}})
+    require.NoError(t, err)
+    st := sc.newStream(1)
+    st.req = req
+    sc.lastStreamID = 1
+    sc.streams[1] = st
+
+    // Test: Data within the windows is buffered
+    require.NoError(t, sc.processData(Frame{Type: FrameData, StreamID: 1, Payload: []byte("hello")}))
+    assert.Equal(t, "hello", string(st.req.Body))
+    assert.Equal(t, int64(defaultWindowSize - 5), st.recvWindow)
+    assert.Equal(t, int64(defaultWindowSize - 5), sc.recvWindow)
+
+    // Test: Windows are topped up once half used
+    require.NoError(t, sc.processData(Frame{Type: FrameData, StreamID: 1, Payload: make([]byte, defaultWindowSize / 2)}))
+    for _, id := range []uint32{0, 1} {
+        f := <-frames
+        assert.Equal(t, FrameWindowUpdate, f.Type)
+        assert.Equal(t, id, f.StreamID)
+    }
+    assert.Equal(t, int64(defaultWindowSize), st.recvWindow)
+    assert.Equal(t, int64(defaultWindowSize), sc.recvWindow)
+
+    // Test: Overrunning the stream window resets the stream
+    st.recvWindow = 10
+    require.NoError(t, sc.processData(Frame{Type: FrameData, StreamID: 1, Payload: make([]byte, 20)}))
+    f := <-frames
+    assert.Equal(t, FrameRSTStream, f.Type)
+    code, err := ParseRSTStream(f.Payload)
+    require.NoError(t, err)
+    assert.Equal(t, ErrCodeFlowControl, code)
+    assert.NotContains(t, sc.streams, uint32(1))
+
+    // Test: Overrunning the connection window is a connection error
+    sc.recvWindow = 10
+    err = sc.processData(Frame{Type: FrameData, StreamID: 1, Payload: make([]byte, 20)})
+    var ce connError
+    require.ErrorAs(t, err, &ce)
+    assert.Equal(t, ErrCodeFlowControl, ce.code)
+}
+
+// rawConn serves one connection over a pipe and returns the client side,
+// past the preface and SETTINGS, with the frames the server sends
+func rawConn(t *testing.T, srv *Server) (net.Conn, <-chan Frame) {
+    serverSide, clientSide := net.Pipe()
+    t.Cleanup(func() { clientSide.Close() })
+    go func() {
+        defer serverSide.Close()
+        srv.ServeConn(serverSide, serverSide)
+    }()
+
+    frames := make(chan Frame, 64)
+    go func() {
+        defer close(frames)
+        for {
+            f, err := ReadFrame(clientSide, defaultMaxFrameSize)
+            if err != nil {
+                return
+            }
+            frames <- f
+        }
+    }()
+
+    _, err := clientSide.Write([]byte(ClientPreface))
+    require.NoError(t, err)
+    require.NoError(t, WriteFrame(clientSide, SettingsFrame(nil)))
+    return clientSide, frames
+}
+
+// nextFrame returns the next frame of type typ, skipping the others
+func nextFrame(t *testing.T, frames <-chan Frame, typ uint8) Frame {
+    timeout := time.After(5 * time.Second)
+    for {
+        select {
+        case f, ok := <-frames:
+            require.True(t, ok, "connection closed")
+            if f.Type == typ {
+                return f
+            }
+        case <-timeout:
+            require.FailNow(t, "timed out waiting for frame")
+        }
+    }
+}
+
+func TestHeaderListLimit(t *testing.T) {
+    srv := &Server{
+        Handler: func(w *response.Writer, req *request.Request) {
+            w.WriteHttpMessage(200, response.GetDefaultHeaders(0), nil)
+        },
+        Context: context.Background(),
+    }
+    conn, frames := rawConn(t, srv)
+
+    // Test: The limit is advertised
+    settings, err := ParseSettings(nextFrame(t, frames, FrameSettings).Payload)
+    require.NoError(t, err)
+    assert.Contains(t, settings, Setting{ID: SettingMaxHeaderListSize, Value: maxHeaderListSize})
+
+    // Test: A small block indexing a large entry over and over gets 431
+    block := (&Encoder{}).Encode([]HeaderField{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}})
+    block = appendInt(block, 0x40, 6, 0)
+    block = appendInt(block, 0, 7, 5)
+    block = append(block, "x-big"...)
+    block = appendInt(block, 0, 7, 4000)
+    block = append(block, strings.Repeat("a", 4000)...)
+    for i := 0; i < 20; i++ {
+        block = appendInt(block, 0x80, 7, uint64(len(staticTable) + 1))
+    }
+    require.NoError(t, WriteFrame(conn, Frame{
+        Type: FrameHeaders,
+        Flags: FlagEndHeaders | FlagEndStream,
+        StreamID: 1,
+        Payload: block,
+    }))
+    f := nextFrame(t, frames, FrameHeaders)
+    assert.Equal(t, uint32(1), f.StreamID)
+    fields, err := NewDecoder(defaultHeaderTableSize).Decode(f.Payload)
+    require.NoError(t, err)
+    assert.Contains(t, fields, HeaderField{":status", "431"})
+
+    // Test: An endless CONTINUATION stream ends the connection
+    go func() {
+        if WriteFrame(conn, Frame{Type: FrameHeaders, StreamID: 3, Payload: block[:10]}) != nil {
+            return
+        }
+        fragment := make([]byte, defaultMaxFrameSize)
+        for WriteFrame(conn, Frame{Type: FrameContinuation, StreamID: 3, Payload: fragment}) == nil {
+        }
+    }()
+    f = nextFrame(t, frames, FrameGoAway)
+    _, code, err := ParseGoAway(f.Payload)
+    require.NoError(t, err)
+    assert.Equal(t, ErrCodeEnhanceYourCalm, code)
+}
+
+func TestRapidReset(t *testing.T) {
+    release := make(chan struct{})
+    var running, maxRunning atomic.Int32
+    srv := &Server{
+        Handler: func(w *response.Writer, req *request.Request) {
+            n := running.Add(1)
+            for {
+                m := maxRunning.Load()
+                if n <= m || maxRunning.CompareAndSwap(m, n) {
+                    break
+                }
+            }
+            // Ignores the canceled context, like a handler stuck upstream
+            <-release
+            running.Add(-1)
+            w.WriteHttpMessage(200, response.GetDefaultHeaders(0), nil)
+        },
+        Context: context.Background(),
+    }
+    conn, frames := rawConn(t, srv)
+    block := (&Encoder{}).Encode([]HeaderField{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}})
+    open := func(id uint32) error {
+        return WriteFrame(conn, Frame{
+            Type: FrameHeaders,
+            Flags: FlagEndHeaders | FlagEndStream,
+            StreamID: id,
+            Payload: block,
+        })
+    }
+
+    // Test: Streams reset right after opening keep their handler's slot,
+    // the ones past the limit are refused
+    streams := 3 * maxConcurrentStreams
+    go func() {
+        for i := 0; i < streams; i++ {
+            id := uint32(2 * i + 1)
+            if open(id) != nil || WriteFrame(conn, RSTStreamFrame(id, ErrCodeCancel)) != nil {
+                return
+            }
+        }
+    }()
+    for refused := 0; refused < streams - maxConcurrentStreams; {
+        f := nextFrame(t, frames, FrameRSTStream)
+        code, err := ParseRSTStream(f.Payload)
+        require.NoError(t, err)
+        assert.Equal(t, ErrCodeRefusedStream, code)
+        assert.Greater(t, f.StreamID, uint32(2 * maxConcurrentStreams))
+        refused++
+    }
+    assert.Equal(t, int32(maxConcurrentStreams), maxRunning.Load())
+
+    // Test: Slots free up once the handlers return
+    close(release)
+    deadline := time.Now().Add(5 * time.Second)
+    for id := uint32(2 * streams + 1); ; id += 2 {
+        require.NoError(t, open(id))
+        var f Frame
+        for f.StreamID != id {
+            var ok bool
+            f, ok = <-frames
+            require.True(t, ok, "connection closed")
+        }
+        if f.Type == FrameHeaders {
+            assert.Equal(t, id, f.StreamID)
+            break
+        }
+        // Refused while the last handlers were still returning
+        require.True(t, time.Now().Before(deadline), "slots never freed")
+        time.Sleep(10 * time.Millisecond)
+    }
+}
@@ -0,0 +1,68 @@
+package http2
+
+import (
+    "errors"
+)
+
+type huffmanNode struct {
+    children [2]*huffmanNode
+    sym      byte
+    leaf     bool
+}
+
+var huffmanRoot = buildHuffmanTree()
+
+func buildHuffmanTree() *huffmanNode {
+    root := &huffmanNode{}
+
+    for sym, code := range huffmanCodes {
+        length := huffmanCodeLen[sym]
+        node := root
+        for i := int(length) - 1; i >= 0; i-- {
+            bit := (code >> uint(i)) & 1
+            if node.children[bit] == nil {
+                node.children[bit] = &huffmanNode{}
+            }
+            node = node.children[bit]
+        }
+        node.leaf = true
+        node.sym = byte(sym)
+    }
+
+    return root
+}
+
+func huffmanDecode(b []byte) (string, error) {
+    out := []byte{}
+    node := huffmanRoot
+    // Bits read since the last complete symbol, all of which must be ones
+    // if they end up being the padding
+    pending := 0
+    allOnes := true
+
+    for _, c := range b {
+        for i := 7; i >= 0; i-- {
+            bit := (c >> uint(i)) & 1
+            node = node.children[bit]
+            if node == nil {
+                return "", errors.New("hpack: invalid huffman code")
+            }
+            pending++
+            if bit == 0 {
+                allOnes = false
+            }
+            if node.leaf {
+                out = append(out, node.sym)
+                node = huffmanRoot
+                pending = 0
+                allOnes = true
+            }
+        }
+    }
+
+    if pending > 7 || !allOnes {
+        return "", errors.New("hpack: invalid huffman padding")
+    }
+
+    return string(out), nil
+}
@@ -0,0 +1,897 @@
+package http2
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+)
+
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const maxConcurrentStreams = 100
+
+// maxHeaderListSize is advertised as SETTINGS_MAX_HEADER_LIST_SIZE. Larger
+// header lists get 431, header blocks larger than it end the connection.
+const maxHeaderListSize = 64 << 10
+
+// Headers that only make sense for HTTP/1.1 and must not appear in HTTP/2
+var connectionHeaders = map[string]struct{}{
+    "connection":        {},
+    "keep-alive":        {},
+    "proxy-connection":  {},
+    "transfer-encoding": {},
+    "upgrade":           {},
+}
+
+type Handler func(w *response.Writer, req *request.Request)
+
+// Server dispatches the streams of HTTP/2 connections to a Handler.
+type Server struct {
+    Handler Handler
+    // Context is canceled when the server shuts down
+    Context context.Context
+    // HandlerTimeout cancels a stream's request context once it elapses
+    HandlerTimeout time.Duration
+    // ErrorPages is set on every stream's Writer
+    ErrorPages *response.ErrorPages
+    // MaxBodySize answers streams whose body grows larger with 413, 0 means
+    // no limit
+    MaxBodySize int64
+}
+
+type connError struct {
+    code uint32
+    msg  string
+}
+
+func (e connError) Error() string {
+    return fmt.Sprintf("http2 connection error %d: %v", e.code, e.msg)
+}
+
+type serverConn struct {
+    srv  *Server
+    conn net.Conn
+    r    io.Reader
+
+    // writeMu serializes frames on the wire
+    writeMu sync.Mutex
+    enc     Encoder
+    dec     *Decoder
+
+    mu               sync.Mutex
+    cond             *sync.Cond
+    streams          map[uint32]*stream
+    sendWindow       int64
+    // recvWindow is what the client may still send on the connection
+    recvWindow       int64
+    peerInitialWindow int64
+    peerMaxFrameSize int
+    lastStreamID     uint32
+    closed           bool
+    // handlers counts the handlers still running, reset streams included,
+    // so a client resetting its streams can't run more than the limit
+    handlers         int
+
+    ctx    context.Context
+    cancel context.CancelFunc
+    wg     sync.WaitGroup
+}
+
+// ServeConn serves a connection that starts with the client preface
+// (prior knowledge h2c). Bytes are read from r, written to conn.
+func (s *Server) ServeConn(conn net.Conn, r io.Reader) error {
+    sc := s.newConn(conn, r)
+    defer sc.shutdown()
+
+    if err := sc.readPreface(); err != nil {
+        return err
+    }
+    if err := sc.writeFrame(SettingsFrame(sc.localSettings())); err != nil {
+        return err
+    }
+
+    return sc.serve()
+}
+
+// ServeUpgrade serves a connection upgraded from HTTP/1.1 with
+// "Upgrade: h2c". The caller has already sent 101 Switching Protocols, req
+// becomes stream 1 and its HTTP2-Settings header is applied.
+func (s *Server) ServeUpgrade(conn net.Conn, r io.Reader, req *request.Request) error {
+    sc := s.newConn(conn, r)
+    defer sc.shutdown()
+
+    payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.Headers.Get("HTTP2-Settings"), "="))
+    if err != nil {
+        return fmt.Errorf("invalid HTTP2-Settings header: %v", err.Error())
+    }
+    settings, err := ParseSettings(payload)
+    if err != nil {
+        return err
+    }
+    if err := sc.applySettings(settings); err != nil {
+        return err
+    }
+
+    if err := sc.writeFrame(SettingsFrame(sc.localSettings())); err != nil {
+        return err
+    }
+    if err := sc.readPreface(); err != nil {
+        return err
+    }
+
+    st := sc.newStream(1)
+    st.req = req
+    st.recvClosed = true
+    sc.mu.Lock()
+    sc.lastStreamID = 1
+    sc.streams[1] = st
+    sc.mu.Unlock()
+    sc.dispatch(st)
+
+    return sc.serve()
+}
+
+func IsUpgradeRequest(req *request.Request) bool {
+    if req.Headers.Get("HTTP2-Settings") == "" {
+        return false
+    }
+    for _, token := range strings.Split(req.Headers.Get("Upgrade"), ",") {
+        if strings.EqualFold(strings.TrimSpace(token), "h2c") {
+            return true
+        }
+    }
+    return false
+}
+
+func (s *Server) newConn(conn net.Conn, r io.Reader) *serverConn {
+    parent := s.Context
+    if parent == nil {
+        parent = context.Background()
+    }
+    ctx, cancel := context.WithCancel(parent)
+
+    sc := &serverConn{
+        srv: s,
+        conn: conn,
+        r: r,
+        dec: NewDecoder(defaultHeaderTableSize),
+        streams: make(map[uint32]*stream),
+        sendWindow: defaultWindowSize,
+        recvWindow: defaultWindowSize,
+        peerInitialWindow: defaultWindowSize,
+        peerMaxFrameSize: defaultMaxFrameSize,
+        ctx: ctx,
+        cancel: cancel,
+    }
+    sc.cond = sync.NewCond(&sc.mu)
+    sc.dec.SetMaxHeaderListSize(maxHeaderListSize)
+
+    go func() {
+        <-ctx.Done()
+        if parent.Err() != nil {
+            // Server shutdown, tell the client which streams were handled
+            sc.mu.Lock()
+            last := sc.lastStreamID
+            sc.mu.Unlock()
+            sc.writeFrame(GoAwayFrame(last, ErrCodeNo, ""))
+            sc.conn.Close()
+        }
+    }()
+
+    return sc
+}
+
+func (sc *serverConn) localSettings() []Setting {
+    return []Setting{
+        {ID: SettingMaxConcurrentStreams, Value: maxConcurrentStreams},
+        {ID: SettingEnablePush, Value: 0},
+        {ID: SettingMaxHeaderListSize, Value: maxHeaderListSize},
+    }
+}
+
+func (sc *serverConn) readPreface() error {
+    buf := make([]byte, len(ClientPreface))
+    if _, err := io.ReadFull(sc.r, buf); err != nil {
+        return err
+    }
+    if string(buf) != ClientPreface {
+        return errors.New("invalid HTTP/2 client preface")
+    }
+    return nil
+}
+
+func (sc *serverConn) writeFrame(f Frame) error {
+    sc.writeMu.Lock()
+    defer sc.writeMu.Unlock()
+    return WriteFrame(sc.conn, f)
+}
+
+// serve runs the read loop until the connection fails or the client leaves
+func (sc *serverConn) serve() error {
+    // Stream whose header block is still being continued
+    var continuing *stream
+    var block []byte
+    var blockEndStream bool
+
+    for {
+        f, err := ReadFrame(sc.r, defaultMaxFrameSize)
+        if err != nil {
+            if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+                return nil
+            }
+            return sc.fail(connError{ErrCodeFrameSize, err.Error()})
+        }
+
+        if continuing != nil {
+            if f.Type != FrameContinuation || f.StreamID != continuing.id {
+                return sc.fail(connError{ErrCodeProtocol, "expected CONTINUATION frame"})
+            }
+            block = append(block, f.Payload...)
+            if len(block) > maxHeaderListSize {
+                // A list this large can't take fewer bytes to encode
+                return sc.fail(connError{ErrCodeEnhanceYourCalm, "header block too large"})
+            }
+            if f.Has(FlagEndHeaders) {
+                st := continuing
+                continuing = nil
+                if err := sc.processHeaders(st, block, blockEndStream); err != nil {
+                    return sc.fail(err)
+                }
+            }
+            continue
+        }
+
+        switch f.Type {
+        case FrameSettings:
+            if f.StreamID != 0 {
+                return sc.fail(connError{ErrCodeProtocol, "SETTINGS on a stream"})
+            }
+            if f.Has(FlagAck) {
+                continue
+            }
+            settings, err := ParseSettings(f.Payload)
+            if err != nil {
+                return sc.fail(connError{ErrCodeFrameSize, err.Error()})
+            }
+            if err := sc.applySettings(settings); err != nil {
+                return sc.fail(err)
+            }
+            if err := sc.writeFrame(SettingsAckFrame()); err != nil {
+                return err
+            }
+        case FramePing:
+            if len(f.Payload) != 8 || f.StreamID != 0 {
+                return sc.fail(connError{ErrCodeProtocol, "malformed PING"})
+            }
+            if f.Has(FlagAck) {
+                continue
+            }
+            var data [8]byte
+            copy(data[:], f.Payload)
+            if err := sc.writeFrame(PingFrame(data, true)); err != nil {
+                return err
+            }
+        case FrameHeaders:
+            if f.StreamID == 0 {
+                return sc.fail(connError{ErrCodeProtocol, "HEADERS on stream 0"})
+            }
+            fragment, err := f.HeaderBlock()
+            if err != nil {
+                return sc.fail(connError{ErrCodeProtocol, err.Error()})
+            }
+            st, err := sc.streamForHeaders(f.StreamID)
+            if err != nil {
+                return sc.fail(err)
+            }
+            if st == nil {
+                // Refused stream, its header block still has to be decoded
+                // to keep the HPACK state in sync
+                st = &stream{id: f.StreamID, sc: sc, refused: true}
+            }
+            block = append([]byte{}, fragment...)
+            blockEndStream = f.Has(FlagEndStream)
+            if !f.Has(FlagEndHeaders) {
+                continuing = st
+                continue
+            }
+            if err := sc.processHeaders(st, block, blockEndStream); err != nil {
+                return sc.fail(err)
+            }
+        case FrameContinuation:
+            return sc.fail(connError{ErrCodeProtocol, "unexpected CONTINUATION frame"})
+        case FrameData:
+            if err := sc.processData(f); err != nil {
+                return sc.fail(err)
+            }
+        case FrameWindowUpdate:
+            increment, err := ParseWindowUpdate(f.Payload)
+            if err != nil {
+                return sc.fail(connError{ErrCodeFrameSize, err.Error()})
+            }
+            if err := sc.processWindowUpdate(f.StreamID, increment); err != nil {
+                return sc.fail(err)
+            }
+        case FrameRSTStream:
+            if _, err := ParseRSTStream(f.Payload); err != nil {
+                return sc.fail(connError{ErrCodeFrameSize, err.Error()})
+            }
+            sc.resetStream(f.StreamID)
+        case FrameGoAway:
+            // The client won't open new streams, existing ones finish
+            // and the client closes the connection
+            if _, _, err := ParseGoAway(f.Payload); err != nil {
+                return sc.fail(connError{ErrCodeFrameSize, err.Error()})
+            }
+        case FramePushPromise:
+            return sc.fail(connError{ErrCodeProtocol, "client sent PUSH_PROMISE"})
+        default:
+            // PRIORITY and unknown frame types are ignored
+        }
+    }
+}
+
+// fail sends GOAWAY for a connection error and returns it
+func (sc *serverConn) fail(err error) error {
+    code := ErrCodeProtocol
+    var ce connError
+    if errors.As(err, &ce) {
+        code = ce.code
+    }
+
+    sc.mu.Lock()
+    last := sc.lastStreamID
+    sc.mu.Unlock()
+    sc.writeFrame(GoAwayFrame(last, code, err.Error()))
+
+    return err
+}
+
+func (sc *serverConn) shutdown() {
+    sc.mu.Lock()
+    sc.closed = true
+    sc.cond.Broadcast()
+    sc.mu.Unlock()
+
+    sc.cancel()
+    sc.wg.Wait()
+}
+
+func (sc *serverConn) applySettings(settings []Setting) error {
+    sc.mu.Lock()
+    defer sc.mu.Unlock()
+
+    for _, s := range settings {
+        switch s.ID {
+        case SettingInitialWindowSize:
+            if s.Value > maxWindowSize {
+                return connError{ErrCodeFlowControl, "initial window size too large"}
+            }
+            delta := int64(s.Value) - sc.peerInitialWindow
+            sc.peerInitialWindow = int64(s.Value)
+            for _, st := range sc.streams {
+                st.sendWindow += delta
+            }
+            sc.cond.Broadcast()
+        case SettingMaxFrameSize:
+            if s.Value < defaultMaxFrameSize || s.Value > maxFrameSizeLimit {
+                return connError{ErrCodeProtocol, "invalid max frame size"}
+            }
+            sc.peerMaxFrameSize = int(s.Value)
+        case SettingEnablePush:
+            if s.Value > 1 {
+                return connError{ErrCodeProtocol, "invalid enable push value"}
+            }
+        }
+    }
+
+    return nil
+}
+
+// streamForHeaders returns the stream a HEADERS frame belongs to, opening a
+// new one if needed. A nil stream means the stream was refused.
+func (sc *serverConn) streamForHeaders(id uint32) (*stream, error) {
+    sc.mu.Lock()
+    defer sc.mu.Unlock()
+
+    if st, ok := sc.streams[id]; ok {
+        // Trailers for a stream still sending its body
+        if st.recvClosed {
+            return nil, connError{ErrCodeStreamClosed, fmt.Sprintf("HEADERS on half-closed stream %d", id)}
+        }
+        return st, nil
+    }
+
+    if id % 2 == 0 || id <= sc.lastStreamID {
+        return nil, connError{ErrCodeProtocol, fmt.Sprintf("invalid stream id %d", id)}
+    }
+    sc.lastStreamID = id
+
+    if len(sc.streams) >= maxConcurrentStreams || sc.handlers >= maxConcurrentStreams {
+        go sc.writeFrame(RSTStreamFrame(id, ErrCodeRefusedStream))
+        return nil, nil
+    }
+
+    st := sc.newStream(id)
+    sc.streams[id] = st
+
+    return st, nil
+}
+
+func (sc *serverConn) newStream(id uint32) *stream {
+    return &stream{
+        id: id,
+        sc: sc,
+        sendWindow: sc.peerInitialWindow,
+        recvWindow: defaultWindowSize,
+    }
+}
+
+func (sc *serverConn) processHeaders(st *stream, block []byte, endStream bool) error {
+    fields, err := sc.dec.Decode(block)
+    tooLarge := errors.Is(err, ErrHeaderListTooLarge)
+    if err != nil && !tooLarge {
+        return connError{ErrCodeCompression, err.Error()}
+    }
+    if st.refused {
+        return nil
+    }
+
+    if tooLarge {
+        if st.req == nil {
+            // Nothing of the request is kept, the problem goes out as JSON
+            req := request.NewRequest()
+            st.req = &req
+        }
+        sc.reject(st, endStream, response.NewProblem(431, "request header fields too large"))
+        return nil
+    }
+
+    if st.req != nil {
+        // Trailers, only allowed to end the stream
+        if !endStream {
+            return connError{ErrCodeProtocol, "trailers without END_STREAM"}
+        }
+        for _, hf := range fields {
+            if !headers.ValidFieldValue(hf.Value) {
+                sc.resetStream(st.id)
+                return sc.writeFrame(RSTStreamFrame(st.id, ErrCodeProtocol))
+            }
+            st.req.Headers.Set(hf.Name, hf.Value)
+        }
+    } else {
+        req, err := requestFromFields(fields)
+        if err != nil {
+            sc.mu.Lock()
+            delete(sc.streams, st.id)
+            sc.mu.Unlock()
+            return sc.writeFrame(RSTStreamFrame(st.id, ErrCodeProtocol))
+        }
+        st.req = req
+        if !endStream && sc.bodyTooLarge(req.Headers.Get("Content-Length"), 0) {
+            sc.reject(st, false, response.NewProblem(413, "request body too large"))
+            return nil
+        }
+    }
+
+    if endStream {
+        st.recvClosed = true
+        sc.dispatch(st)
+    }
+
+    return nil
+}
+
+// bodyTooLarge reports whether a body of size bytes, or the announced
+// contentLength, is past the limit
+func (sc *serverConn) bodyTooLarge(contentLength string, size int) bool {
+    limit := sc.srv.MaxBodySize
+    if limit <= 0 {
+        return false
+    }
+    if n, err := strconv.ParseInt(contentLength, 10, 64); err == nil && n > limit {
+        return true
+    }
+    return int64(size) > limit
+}
+
+// reject answers st with problem without waiting for the rest of the
+// request. A client still sending is then told to stop with RST_STREAM
+// NO_ERROR.
+func (sc *serverConn) reject(st *stream, ended bool, problem *response.Problem) {
+    st.recvClosed = true
+    st.req.Body = nil
+    st.rejected = !ended
+    sc.dispatchHandler(st, func(w *response.Writer, req *request.Request) {
+        w.WriteProblem(req, problem, nil)
+    })
+}
+
+func requestFromFields(fields []HeaderField) (*request.Request, error) {
+    req := request.NewRequest()
+    var authority string
+    cookies := []string{}
+    sawRegular := false
+
+    for _, hf := range fields {
+        if !headers.ValidFieldValue(hf.Value) {
+            return nil, fmt.Errorf("invalid value for %v", hf.Name)
+        }
+        if strings.HasPrefix(hf.Name, ":") {
+            if sawRegular {
+                return nil, errors.New("pseudo-header after regular header")
+            }
+            switch hf.Name {
+            case ":method":
+                req.RequestLine.Method = hf.Value
+            case ":path":
+                req.RequestLine.RequestTarget = hf.Value
+            case ":authority":
+                authority = hf.Value
+            case ":scheme":
+            default:
+                return nil, fmt.Errorf("unknown pseudo-header %v", hf.Name)
+            }
+            continue
+        }
+        sawRegular = true
+
+        if hf.Name != strings.ToLower(hf.Name) {
+            return nil, fmt.Errorf("uppercase header name %v", hf.Name)
+        }
+        if _, ok := connectionHeaders[hf.Name]; ok {
+            return nil, fmt.Errorf("connection-specific header %v", hf.Name)
+        }
+        if hf.Name == "te" && hf.Value != "trailers" {
+            return nil, errors.New("te header other than trailers")
+        }
+        if hf.Name == "cookie" {
+            cookies = append(cookies, hf.Value)
+            continue
+        }
+        req.Headers.Set(hf.Name, hf.Value)
+    }
+
+    if req.RequestLine.Method == "" {
+        return nil, errors.New("missing :method")
+    }
+    if req.RequestLine.RequestTarget == "" {
+        if req.RequestLine.Method != "CONNECT" {
+            return nil, errors.New("missing :path")
+        }
+        req.RequestLine.RequestTarget = authority
+    }
+    if len(cookies) > 0 {
+        req.Headers.Replace("Cookie", strings.Join(cookies, "; "))
+    }
+    if authority != "" && req.Headers.Get("Host") == "" {
+        req.Headers.Set("Host", authority)
+    }
+    req.RequestLine.HttpVersion = "2"
+
+    u, err := request.ParseTarget(req.RequestLine.Method, req.RequestLine.RequestTarget)
+    if err != nil {
+        return nil, err
+    }
+    req.URL = u
+
+    return &req, nil
+}
+
+func (sc *serverConn) processData(f Frame) error {
+    sc.mu.Lock()
+    st, ok := sc.streams[f.StreamID]
+    sc.mu.Unlock()
+
+    if f.StreamID == 0 {
+        return connError{ErrCodeProtocol, "DATA on stream 0"}
+    }
+    if f.StreamID > sc.lastStreamID {
+        return connError{ErrCodeProtocol, "DATA on idle stream"}
+    }
+
+    // The whole frame counts against the windows, padding included
+    length := int64(len(f.Payload))
+    if length > sc.recvWindow {
+        return connError{ErrCodeFlowControl, "DATA past the connection window"}
+    }
+    sc.recvWindow -= length
+
+    // Everything is buffered into Request.Body, so the data counts as
+    // consumed right away and MaxBodySize is what bounds the buffering.
+    // Windows are topped up once half used, sparing an update per frame.
+    if err := sc.replenish(0, &sc.recvWindow); err != nil {
+        return err
+    }
+
+    if !ok || st.recvClosed || st.req == nil {
+        if ok && st.rejected {
+            // Sent before the client saw our RST_STREAM
+            return nil
+        }
+        return sc.writeFrame(RSTStreamFrame(f.StreamID, ErrCodeStreamClosed))
+    }
+
+    if length > st.recvWindow {
+        sc.resetStream(st.id)
+        return sc.writeFrame(RSTStreamFrame(st.id, ErrCodeFlowControl))
+    }
+    st.recvWindow -= length
+
+    data, err := f.DataPayload()
+    if err != nil {
+        return connError{ErrCodeProtocol, err.Error()}
+    }
+    if sc.bodyTooLarge("", len(st.req.Body) + len(data)) {
+        sc.reject(st, f.Has(FlagEndStream), response.NewProblem(413, "request body too large"))
+        return nil
+    }
+    st.req.Body = append(st.req.Body, data...)
+
+    if f.Has(FlagEndStream) {
+        st.recvClosed = true
+        sc.dispatch(st)
+        return nil
+    }
+
+    return sc.replenish(st.id, &st.recvWindow)
+}
+
+// replenish sends a WINDOW_UPDATE restoring window to its full size once
+// it has dropped below half of it
+func (sc *serverConn) replenish(id uint32, window *int64) error {
+    if *window >= defaultWindowSize / 2 {
+        return nil
+    }
+    increment := defaultWindowSize - *window
+    *window += increment
+    return sc.writeFrame(WindowUpdateFrame(id, uint32(increment)))
+}
+
+func (sc *serverConn) processWindowUpdate(id uint32, increment uint32) error {
+    sc.mu.Lock()
+    defer sc.mu.Unlock()
+
+    if increment == 0 {
+        if id == 0 {
+            return connError{ErrCodeProtocol, "zero WINDOW_UPDATE increment"}
+        }
+        go sc.writeFrame(RSTStreamFrame(id, ErrCodeProtocol))
+        return nil
+    }
+
+    if id == 0 {
+        sc.sendWindow += int64(increment)
+        if sc.sendWindow > maxWindowSize {
+            return connError{ErrCodeFlowControl, "connection window overflow"}
+        }
+    } else if st, ok := sc.streams[id]; ok {
+        st.sendWindow += int64(increment)
+        if st.sendWindow > maxWindowSize {
+            go sc.writeFrame(RSTStreamFrame(id, ErrCodeFlowControl))
+            st.reset = true
+            if st.cancel != nil {
+                st.cancel()
+            }
+        }
+    }
+    sc.cond.Broadcast()
+
+    return nil
+}
+
+func (sc *serverConn) resetStream(id uint32) {
+    sc.mu.Lock()
+    defer sc.mu.Unlock()
+
+    st, ok := sc.streams[id]
+    if !ok {
+        return
+    }
+    st.reset = true
+    if st.cancel != nil {
+        st.cancel()
+    }
+    delete(sc.streams, id)
+    sc.cond.Broadcast()
+}
+
+func (sc *serverConn) dispatch(st *stream) {
+    sc.dispatchHandler(st, sc.srv.Handler)
+}
+
+func (sc *serverConn) dispatchHandler(st *stream, handler Handler) {
+    var ctx context.Context
+    var cancel context.CancelFunc
+    if sc.srv.HandlerTimeout > 0 {
+        ctx, cancel = context.WithTimeout(sc.ctx, sc.srv.HandlerTimeout)
+    } else {
+        ctx, cancel = context.WithCancel(sc.ctx)
+    }
+
+    sc.mu.Lock()
+    st.cancel = cancel
+    sc.handlers++
+    sc.mu.Unlock()
+
+    req := st.req.WithContext(ctx)
+
+    sc.wg.Add(1)
+    go func() {
+        defer sc.wg.Done()
+        defer cancel()
+
+        w := response.NewSinkWriter(st)
+        w.SetErrorPages(sc.srv.ErrorPages)
+        handler(w, req)
+        if errors.Is(ctx.Err(), context.DeadlineExceeded) && !w.Written() {
+            w.WriteProblem(req, response.NewProblem(503, "the request took too long to handle"), nil)
+        }
+        st.Close(headers.NewHeaders())
+        req.Cleanup()
+        if st.rejected {
+            sc.writeFrame(RSTStreamFrame(st.id, ErrCodeNo))
+        }
+
+        sc.mu.Lock()
+        delete(sc.streams, st.id)
+        sc.handlers--
+        sc.mu.Unlock()
+    }()
+}
+
+// stream implements response.Sink for a single HTTP/2 stream
+type stream struct {
+    id          uint32
+    sc          *serverConn
+    req         *request.Request
+    sendWindow  int64
+    // recvWindow is what the client may still send on the stream
+    recvWindow  int64
+    recvClosed  bool
+    // rejected streams were answered before their body ended
+    rejected    bool
+    refused     bool
+    reset       bool
+    headersSent bool
+    done        bool
+    cancel      context.CancelFunc
+}
+
+func (st *stream) WriteHeader(statusCode int, h headers.Headers) error {
+    if st.headersSent {
+        return errors.New("http2: headers already sent")
+    }
+    st.headersSent = true
+    if statusCode == 0 {
+        statusCode = 200
+    }
+
+    fields := []HeaderField{{":status", fmt.Sprintf("%d", statusCode)}}
+    fields = append(fields, headerFields(h)...)
+
+    return st.writeHeaderBlock(fields, false)
+}
+
+func (st *stream) Write(p []byte) (int, error) {
+    if st.done {
+        return 0, errors.New("http2: write after end of stream")
+    }
+    if !st.headersSent {
+        if err := st.WriteHeader(200, headers.NewHeaders()); err != nil {
+            return 0, err
+        }
+    }
+
+    written := 0
+    for len(p) > 0 {
+        n, err := st.reserve(len(p))
+        if err != nil {
+            return written, err
+        }
+        err = st.sc.writeFrame(Frame{Type: FrameData, StreamID: st.id, Payload: p[:n]})
+        if err != nil {
+            return written, err
+        }
+        written += n
+        p = p[n:]
+    }
+
+    return written, nil
+}
+
+// reserve blocks until flow control allows sending some of want bytes
+func (st *stream) reserve(want int) (int, error) {
+    sc := st.sc
+    sc.mu.Lock()
+    defer sc.mu.Unlock()
+
+    for {
+        if sc.closed {
+            return 0, errors.New("http2: connection closed")
+        }
+        if st.reset {
+            return 0, errors.New("http2: stream reset by client")
+        }
+        if sc.sendWindow > 0 && st.sendWindow > 0 {
+            break
+        }
+        sc.cond.Wait()
+    }
+
+    n := min(int64(want), sc.sendWindow, st.sendWindow, int64(sc.peerMaxFrameSize))
+    sc.sendWindow -= n
+    st.sendWindow -= n
+
+    return int(n), nil
+}
+
+func (st *stream) Close(trailers headers.Headers) error {
+    if st.done {
+        return nil
+    }
+    st.sc.mu.Lock()
+    reset := st.reset
+    st.sc.mu.Unlock()
+    if reset {
+        st.done = true
+        return nil
+    }
+
+    if !st.headersSent {
+        if err := st.WriteHeader(200, headers.NewHeaders()); err != nil {
+            return err
+        }
+    }
+    st.done = true
+
+    fields := headerFields(trailers)
+    if len(fields) > 0 {
+        return st.writeHeaderBlock(fields, true)
+    }
+    return st.sc.writeFrame(Frame{Type: FrameData, Flags: FlagEndStream, StreamID: st.id})
+}
+
+func (st *stream) writeHeaderBlock(fields []HeaderField, endStream bool) error {
+    sc := st.sc
+    sc.mu.Lock()
+    maxSize := sc.peerMaxFrameSize
+    sc.mu.Unlock()
+
+    // The block and its frames must go out together
+    sc.writeMu.Lock()
+    defer sc.writeMu.Unlock()
+
+    block := sc.enc.Encode(fields)
+    for _, f := range HeaderFrames(st.id, block, endStream, maxSize) {
+        if err := WriteFrame(sc.conn, f); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func headerFields(h headers.Headers) []HeaderField {
+    fields := []HeaderField{}
+    for key := range h {
+        name := strings.ToLower(key)
+        if _, ok := connectionHeaders[name]; ok {
+            continue
+        }
+        for _, value := range h.Values(key) {
+            fields = append(fields, HeaderField{name, value})
+        }
+    }
+    return fields
+}
@@ -0,0 +1,403 @@
+package nethttp
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/aringq10/http-go-server/internal/server"
+)
+
+// Bodies up to this size are held back so they can get a Content-Length
+// and a sniffed Content-Type, larger ones are sent chunked
+const bufferSize = 4096
+
+// FromHTTP runs a net/http handler on server.Server. The handler's
+// ResponseWriter implements http.Flusher and http.Hijacker, hijacking
+// fails under HTTP/2. RemoteAddr and TLS aren't known and stay empty.
+func FromHTTP(handler http.Handler) server.Handler {
+    return func(w *response.Writer, req *request.Request) {
+        r, err := httpRequest(req)
+        if err != nil {
+            w.WriteProblem(req, response.NewProblem(400, err.Error()), nil)
+            return
+        }
+
+        rw := &responseWriter{w: w, req: req, header: http.Header{}}
+        rw.noBody = req.RequestLine.Method == "HEAD"
+        handler.ServeHTTP(rw, r)
+        rw.finish()
+    }
+}
+
+// httpRequest translates req for a net/http handler
+func httpRequest(req *request.Request) (*http.Request, error) {
+    target := req.RequestLine.RequestTarget
+    var u *url.URL
+    if req.URL.Form == request.TargetAuthority {
+        u = &url.URL{Host: target}
+    } else {
+        var err error
+        if u, err = url.ParseRequestURI(target); err != nil {
+            return nil, err
+        }
+    }
+
+    major, minor := 1, 1
+    if req.RequestLine.HttpVersion == "2" {
+        major, minor = 2, 0
+    }
+
+    header := http.Header{}
+    for key := range req.Headers {
+        for _, value := range req.Headers.Values(key) {
+            header.Add(http.CanonicalHeaderKey(key), value)
+        }
+    }
+    // net/http keeps Host out of Header
+    host := header.Get("Host")
+    header.Del("Host")
+    if u.Host != "" {
+        host = u.Host
+    }
+
+    // Streamed bodies are still on the connection, their length is only
+    // in the header
+    length := int64(len(req.Body))
+    if n, err := strconv.ParseInt(req.Headers.Get("Content-Length"), 10, 64); err == nil && n >= 0 {
+        length = n
+    }
+
+    r := &http.Request{
+        Method: req.RequestLine.Method,
+        URL: u,
+        Proto: fmt.Sprintf("HTTP/%d.%d", major, minor),
+        ProtoMajor: major,
+        ProtoMinor: minor,
+        Header: header,
+        Body: http.NoBody,
+        ContentLength: length,
+        Host: host,
+        RequestURI: target,
+    }
+    if length > 0 {
+        r.Body = io.NopCloser(req.BodyReader())
+    }
+
+    return r.WithContext(req.Context()), nil
+}
+
+// responseWriter implements http.ResponseWriter on top of response.Writer
+type responseWriter struct {
+    w      *response.Writer
+    req    *request.Request
+    header http.Header
+    status int
+    // buf holds the start of the body until the headers go out
+    buf      []byte
+    flushed  bool
+    chunked  bool
+    noBody   bool
+    hijacked bool
+}
+
+func (rw *responseWriter) Header() http.Header {
+    return rw.header
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+    // Informational responses aren't supported, only the final one is sent
+    if rw.status != 0 || rw.hijacked || statusCode < 200 {
+        return
+    }
+    rw.status = statusCode
+    if statusCode == 204 || statusCode == 304 {
+        rw.noBody = true
+    }
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+    if rw.hijacked {
+        return 0, http.ErrHijacked
+    }
+    rw.WriteHeader(200)
+    if rw.status == 204 || rw.status == 304 {
+        return 0, http.ErrBodyNotAllowed
+    }
+
+    if !rw.flushed {
+        rw.buf = append(rw.buf, p...)
+        if len(rw.buf) <= bufferSize {
+            return len(p), nil
+        }
+        if err := rw.flush(false); err != nil {
+            return 0, err
+        }
+        return len(p), nil
+    }
+
+    return rw.writeBody(p)
+}
+
+// Flush implements http.Flusher. It sends the headers and anything
+// buffered, the body is chunked from then on unless it has a length.
+func (rw *responseWriter) Flush() {
+    if rw.hijacked {
+        return
+    }
+    rw.WriteHeader(200)
+    if !rw.flushed {
+        rw.flush(false)
+    }
+}
+
+// Hijack implements http.Hijacker.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    if rw.flushed {
+        return nil, nil, errors.New("response already started")
+    }
+    conn, buffered, err := rw.w.Hijack()
+    if err != nil {
+        return nil, nil, err
+    }
+    rw.hijacked = true
+
+    r := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+    return conn, bufio.NewReadWriter(r, bufio.NewWriter(conn)), nil
+}
+
+// flush writes the status line, the headers and the buffered body. final
+// means the handler is done, so the buffer is the whole body.
+func (rw *responseWriter) flush(final bool) error {
+    rw.flushed = true
+
+    h := headers.NewHeaders()
+    h.Set("Connection", "close")
+    for key, values := range rw.header {
+        if strings.HasPrefix(key, http.TrailerPrefix) {
+            continue
+        }
+        for _, value := range values {
+            h.Add(key, value)
+        }
+    }
+
+    _, typeSet := rw.header["Content-Type"]
+    if !typeSet && len(rw.buf) > 0 {
+        h.Replace("Content-Type", http.DetectContentType(rw.buf))
+    }
+
+    if h.Get("Content-Length") == "" && !(rw.status == 204 || rw.status == 304) {
+        if final && !rw.hasTrailers() {
+            h.Replace("Content-Length", strconv.Itoa(len(rw.buf)))
+        } else {
+            h.Remove("Content-Length")
+            h.Replace("Transfer-Encoding", "chunked")
+            rw.chunked = true
+        }
+    }
+
+    if err := rw.w.WriteStatusLine(rw.status); err != nil {
+        log.Println("nethttp:", err)
+        rw.noBody = true
+        return rw.w.WriteProblem(rw.req, response.NewProblem(500, ""), nil)
+    }
+    if err := rw.w.WriteHeaders(h); err != nil {
+        return err
+    }
+
+    buf := rw.buf
+    rw.buf = nil
+    _, err := rw.writeBody(buf)
+    return err
+}
+
+func (rw *responseWriter) writeBody(p []byte) (int, error) {
+    if rw.noBody || len(p) == 0 {
+        return len(p), nil
+    }
+    if rw.chunked {
+        return rw.w.WriteChunkedBody(p)
+    }
+    return rw.w.WriteBody(p)
+}
+
+// hasTrailers reports whether the handler declared or set any trailers
+func (rw *responseWriter) hasTrailers() bool {
+    if _, ok := rw.header["Trailer"]; ok {
+        return true
+    }
+    for key := range rw.header {
+        if strings.HasPrefix(key, http.TrailerPrefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// finish ends the response once the handler returns
+func (rw *responseWriter) finish() {
+    if rw.hijacked {
+        return
+    }
+    rw.WriteHeader(200)
+    if !rw.flushed {
+        if err := rw.flush(true); err != nil {
+            return
+        }
+    }
+    if !rw.chunked || rw.noBody {
+        rw.w.Finish()
+        return
+    }
+
+    if _, err := rw.w.WriteChunkedBodyDone(); err != nil {
+        return
+    }
+    trailers := headers.NewHeaders()
+    for _, declared := range rw.header.Values("Trailer") {
+        for _, key := range strings.Split(declared, ",") {
+            key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+            for _, value := range rw.header.Values(key) {
+                trailers.Add(key, value)
+            }
+        }
+    }
+    for key, values := range rw.header {
+        if name, ok := strings.CutPrefix(key, http.TrailerPrefix); ok {
+            for _, value := range values {
+                trailers.Add(name, value)
+            }
+        }
+    }
+    rw.w.WriteHeaders(trailers)
+}
+
+// ToHTTP exposes handler as a net/http handler, e.g. to mount it on an
+// http.ServeMux or drive it with httptest. The request body is read in
+// full before handler runs, as server.Server does.
+func ToHTTP(handler server.Handler) http.Handler {
+    return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+        req, err := ownRequest(r)
+        if err != nil {
+            http.Error(rw, err.Error(), 400)
+            return
+        }
+
+        var w *response.Writer
+        if hj, ok := rw.(http.Hijacker); ok {
+            w = response.NewHijackableSinkWriter(&sink{rw: rw}, func() (net.Conn, []byte, error) {
+                conn, brw, err := hj.Hijack()
+                if err != nil {
+                    return nil, nil, err
+                }
+                buffered, _ := brw.Reader.Peek(brw.Reader.Buffered())
+                return conn, buffered, nil
+            })
+        } else {
+            w = response.NewSinkWriter(&sink{rw: rw})
+        }
+
+        handler(w, req)
+        w.Finish()
+        req.Cleanup()
+    })
+}
+
+// ownRequest translates a net/http request for handlers of this server
+func ownRequest(r *http.Request) (*request.Request, error) {
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+        return nil, err
+    }
+
+    req := request.NewRequest()
+    req.RequestLine.Method = r.Method
+    req.RequestLine.RequestTarget = r.RequestURI
+    if req.RequestLine.RequestTarget == "" {
+        req.RequestLine.RequestTarget = r.URL.RequestURI()
+    }
+    req.RequestLine.HttpVersion = "1.1"
+    if r.ProtoMajor == 2 {
+        req.RequestLine.HttpVersion = "2"
+    }
+
+    for key, values := range r.Header {
+        for _, value := range values {
+            if strings.EqualFold(key, "Cookie") {
+                if existing := req.Headers.Get(key); existing != "" {
+                    value = existing + "; " + value
+                }
+                req.Headers.Replace(key, value)
+                continue
+            }
+            req.Headers.Set(key, value)
+        }
+    }
+    if r.Host != "" {
+        req.Headers.Replace("Host", r.Host)
+    }
+    req.Body = body
+
+    u, err := request.ParseTarget(req.RequestLine.Method, req.RequestLine.RequestTarget)
+    if err != nil {
+        return nil, err
+    }
+    req.URL = u
+
+    return req.WithContext(r.Context()), nil
+}
+
+// sink implements response.Sink on an http.ResponseWriter, which does its
+// own framing
+type sink struct {
+    rw http.ResponseWriter
+}
+
+func (s *sink) WriteHeader(statusCode int, h headers.Headers) error {
+    header := s.rw.Header()
+    for key := range h {
+        switch strings.ToLower(key) {
+        case "connection", "transfer-encoding":
+            continue
+        }
+        for _, value := range h.Values(key) {
+            header.Add(http.CanonicalHeaderKey(key), value)
+        }
+    }
+    if statusCode == 0 {
+        statusCode = 200
+    }
+    s.rw.WriteHeader(statusCode)
+    return nil
+}
+
+func (s *sink) Write(p []byte) (int, error) {
+    n, err := s.rw.Write(p)
+    // Streaming handlers expect each write to reach the client
+    if f, ok := s.rw.(http.Flusher); ok {
+        f.Flush()
+    }
+    return n, err
+}
+
+func (s *sink) Close(trailers headers.Headers) error {
+    header := s.rw.Header()
+    for key := range trailers {
+        for _, value := range trailers.Values(key) {
+            header.Add(http.TrailerPrefix + http.CanonicalHeaderKey(key), value)
+        }
+    }
+    return nil
+}
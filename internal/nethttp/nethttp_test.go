@@ -0,0 +1,132 @@
+package nethttp
+
+import (
+    "bufio"
+    "bytes"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// serve runs handler for a raw request and parses what it wrote
+func serve(t *testing.T, handler http.Handler, rawRequest string) (*http.Response, string) {
+    req, err := request.RequestFromReader(strings.NewReader(rawRequest))
+    require.NoError(t, err)
+
+    buf := &bytes.Buffer{}
+    FromHTTP(handler)(response.NewWriter(buf), req)
+
+    resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+    require.NoError(t, err)
+    body, err := io.ReadAll(resp.Body)
+    require.NoError(t, err)
+    return resp, string(body)
+}
+
+func TestFromHTTP(t *testing.T) {
+    handler := http.NewServeMux()
+    handler.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        w.Header().Set("X-Host", r.Host)
+        w.Header().Set("X-Query", r.URL.Query().Get("q"))
+        w.Header().Add("Set-Cookie", "a=1")
+        w.Header().Add("Set-Cookie", "b=2")
+        w.WriteHeader(201)
+        w.Write([]byte(r.Method + " " + string(body)))
+    })
+    handler.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Trailer", "X-Count")
+        w.Write([]byte("one "))
+        w.(http.Flusher).Flush()
+        w.Write([]byte("two"))
+        w.Header().Set("X-Count", "2")
+    })
+    handler.HandleFunc("/teapot", func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(418)
+        w.Write([]byte("short and stout"))
+    })
+    handler.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("<html><body>hi</body></html>"))
+    })
+
+    // Test: Request and response translated both ways
+    raw := "POST /echo?q=banger HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello"
+    resp, body := serve(t, handler, raw)
+    assert.Equal(t, 201, resp.StatusCode)
+    assert.Equal(t, "POST hello", body)
+    assert.Equal(t, "localhost:42069", resp.Header.Get("X-Host"))
+    assert.Equal(t, "banger", resp.Header.Get("X-Query"))
+    assert.Equal(t, []string{"a=1", "b=2"}, resp.Header.Values("Set-Cookie"))
+    assert.Equal(t, int64(10), resp.ContentLength)
+
+    // Test: Flush switches to chunked and trailers follow the body
+    resp, body = serve(t, handler, "GET /stream HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+    assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+    assert.Equal(t, "one two", body)
+    assert.Equal(t, "2", resp.Trailer.Get("X-Count"))
+
+    // Test: Codes the Writer has no reason phrase for pass through as is
+    resp, body = serve(t, handler, "GET /teapot HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+    assert.Equal(t, 418, resp.StatusCode)
+    assert.Equal(t, "short and stout", body)
+
+    // Test: Content type is sniffed when unset
+    resp, _ = serve(t, handler, "GET /html HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+    assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+    // Test: ServeMux's own 404
+    resp, _ = serve(t, handler, "GET /nope HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+    assert.Equal(t, 404, resp.StatusCode)
+
+    // Test: Hijacking fails without a connection behind the writer
+    hijackErr := error(nil)
+    hijacker := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        _, _, hijackErr = w.(http.Hijacker).Hijack()
+    })
+    serve(t, hijacker, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+    assert.ErrorIs(t, hijackErr, response.ErrNotHijackable)
+}
+
+func TestToHTTP(t *testing.T) {
+    var got *request.Request
+    handler := ToHTTP(func(w *response.Writer, req *request.Request) {
+        got = req
+        h := response.GetDefaultHeaders(0)
+        h.Add("Set-Cookie", "a=1")
+        h.Add("Set-Cookie", "b=2")
+        w.WriteHttpMessage(200, h, []byte("hello " + req.URL.Query.Get("name")))
+    })
+
+    // Test: Request translated, response written through the recorder
+    r := httptest.NewRequest("POST", "/greet?name=lane", strings.NewReader("body"))
+    r.Header.Add("Cookie", "x=1")
+    r.Header.Add("Cookie", "y=2")
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, r)
+    assert.Equal(t, 200, rec.Code)
+    assert.Equal(t, "hello lane", rec.Body.String())
+    assert.Equal(t, []string{"a=1", "b=2"}, rec.Header().Values("Set-Cookie"))
+    assert.Empty(t, rec.Header().Get("Connection"))
+    assert.Equal(t, "/greet", got.URL.Path)
+    assert.Equal(t, "example.com", got.Headers.Get("Host"))
+    assert.Equal(t, "body", string(got.Body))
+    assert.Equal(t, map[string]string{"x": "1", "y": "2"}, got.Cookies())
+
+    // Test: Chunked responses stream and carry trailers
+    handler = ToHTTP(func(w *response.Writer, req *request.Request) {
+        h := response.GetDefaultHeaders(0)
+        w.WriteChunksFromReader(strings.NewReader("streamed"), h)
+    })
+    rec = httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+    assert.Equal(t, "streamed", rec.Body.String())
+    assert.True(t, rec.Flushed)
+    assert.Equal(t, "8", rec.Result().Trailer.Get("X-Content-Length"))
+}
@@ -0,0 +1,80 @@
+package request
+
+import (
+    "errors"
+    "fmt"
+    "mime"
+    "strings"
+)
+
+const DefaultMaxFormSize = 10 << 20
+
+var ErrFormTooLarge = errors.New("form body too large")
+
+// ParseForm fills Form and PostForm with DefaultMaxFormSize as the body
+// limit, see ParseFormLimit.
+func (r *Request) ParseForm() error {
+    return r.ParseFormLimit(DefaultMaxFormSize)
+}
+
+// ParseFormLimit fills PostForm from an application/x-www-form-urlencoded
+// body of a POST, PUT or PATCH request and Form from those values followed
+// by the query parameters. Bodies of other types are left alone. Calling it
+// again once it succeeded does nothing.
+func (r *Request) ParseFormLimit(maxSize int) error {
+    if r.Form != nil {
+        return nil
+    }
+
+    postForm := Query{}
+    method := r.RequestLine.Method
+    if method == "POST" || method == "PUT" || method == "PATCH" {
+        mediaType := ""
+        if contentType := r.Headers.Get("Content-Type"); contentType != "" {
+            var err error
+            mediaType, _, err = mime.ParseMediaType(contentType)
+            if err != nil {
+                return fmt.Errorf("error while parsing form content type: %w", err)
+            }
+        }
+
+        if mediaType == "application/x-www-form-urlencoded" {
+            if err := r.ReadBody(maxSize); errors.Is(err, ErrBodyTooLarge) {
+                return ErrFormTooLarge
+            } else if err != nil {
+                return fmt.Errorf("error while reading form body: %w", err)
+            }
+            var err error
+            postForm, err = ParseQuery(strings.TrimSpace(string(r.Body)))
+            if err != nil {
+                return fmt.Errorf("error while parsing form body: %w", err)
+            }
+        }
+    }
+
+    form := Query{}
+    for key, values := range postForm {
+        form[key] = append(form[key], values...)
+    }
+    for key, values := range r.URL.Query {
+        form[key] = append(form[key], values...)
+    }
+
+    r.PostForm = postForm
+    r.Form = form
+    return nil
+}
+
+// FormValue returns the first value for key from the body or the query,
+// parsing the form if needed. Parse errors are ignored, call ParseForm to
+// see them.
+func (r *Request) FormValue(key string) string {
+    r.ParseForm()
+    return r.Form.Get(key)
+}
+
+// PostFormValue is FormValue for body values only.
+func (r *Request) PostFormValue(key string) string {
+    r.ParseForm()
+    return r.PostForm.Get(key)
+}
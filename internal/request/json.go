@@ -0,0 +1,87 @@
+package request
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "mime"
+    "strings"
+)
+
+const DefaultMaxJSONSize = 1 << 20
+
+// BodyError is returned when a request body can't be decoded. Status is the
+// response code that fits it: 400, 413 or 415.
+type BodyError struct {
+    Status int
+    Err    error
+}
+
+func (e *BodyError) Error() string {
+    return e.Err.Error()
+}
+
+func (e *BodyError) Unwrap() error {
+    return e.Err
+}
+
+// DecodeJSON decodes a JSON body into a T with DefaultMaxJSONSize as the
+// limit, see DecodeJSONLimit.
+func DecodeJSON[T any](r *Request) (T, error) {
+    return DecodeJSONLimit[T](r, DefaultMaxJSONSize)
+}
+
+// DecodeJSONLimit decodes a JSON body into a T. The body must be
+// application/json or a +json type, hold exactly one value, fit in maxSize
+// bytes and only use fields T knows about.
+func DecodeJSONLimit[T any](r *Request, maxSize int) (T, error) {
+    var v T
+
+    mediaType, _, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+    if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+        return v, &BodyError{415, errors.New("content type must be application/json")}
+    }
+    if err := r.ReadBody(maxSize); errors.Is(err, ErrBodyTooLarge) {
+        return v, &BodyError{413, fmt.Errorf("body must not be larger than %d bytes", maxSize)}
+    } else if err != nil {
+        return v, &BodyError{400, fmt.Errorf("error while reading body: %w", err)}
+    }
+    if len(bytes.TrimSpace(r.Body)) == 0 {
+        return v, &BodyError{400, errors.New("body must not be empty")}
+    }
+
+    dec := json.NewDecoder(bytes.NewReader(r.Body))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&v); err != nil {
+        return v, &BodyError{400, jsonError(err)}
+    }
+    if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+        return v, &BodyError{400, errors.New("body must hold a single JSON value")}
+    }
+
+    return v, nil
+}
+
+// jsonError rewords decoding errors into something a client can act on
+func jsonError(err error) error {
+    var syntaxErr *json.SyntaxError
+    var typeErr *json.UnmarshalTypeError
+
+    switch {
+    case errors.As(err, &syntaxErr):
+        return fmt.Errorf("malformed JSON at offset %d", syntaxErr.Offset)
+    case errors.Is(err, io.ErrUnexpectedEOF):
+        return errors.New("malformed JSON, body ends early")
+    case errors.As(err, &typeErr):
+        if typeErr.Field != "" {
+            return fmt.Errorf("field %q must be %v", typeErr.Field, typeErr.Type)
+        }
+        return fmt.Errorf("body must be %v", typeErr.Type)
+    case strings.HasPrefix(err.Error(), "json: unknown field "):
+        return fmt.Errorf("unknown field %v", strings.TrimPrefix(err.Error(), "json: unknown field "))
+    default:
+        return err
+    }
+}
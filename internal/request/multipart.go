@@ -0,0 +1,478 @@
+package request
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "mime"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+)
+
+const (
+    DefaultMaxMemory     = 10 << 20
+    DefaultMaxPartSize   = 32 << 20
+    DefaultMaxUploadSize = 64 << 20
+    DefaultMaxParts      = 1000
+    maxPartHeaderSize    = 16 << 10
+    multipartBufferSize  = 16 << 10
+)
+
+var (
+    ErrNotMultipart      = errors.New("request is not multipart/form-data")
+    ErrPartTooLarge      = errors.New("multipart part too large")
+    ErrMultipartTooLarge = errors.New("multipart body too large")
+    ErrTooManyParts      = errors.New("too many multipart parts")
+    ErrValuesTooLarge    = errors.New("multipart values exceed the memory limit")
+)
+
+// MultipartLimits bounds ParseMultipartForm, zero fields take the defaults.
+type MultipartLimits struct {
+    // MaxMemory is how much of the form is held in memory. Values can't
+    // spill and fail with ErrValuesTooLarge past it, what's left of it
+    // goes to file parts before they spill to temp files.
+    MaxMemory    int64
+    MaxPartSize  int64
+    // MaxTotalSize counts every part's content
+    MaxTotalSize int64
+    MaxParts     int
+}
+
+// MultipartForm is a parsed multipart/form-data body.
+type MultipartForm struct {
+    Value Query
+    File  map[string][]*FileHeader
+}
+
+// FileHeader describes an uploaded file, its content is kept in memory or
+// in a temp file.
+type FileHeader struct {
+    Filename string
+    Headers  headers.Headers
+    Size     int64
+    content  []byte
+    tmpFile  string
+}
+
+// File is an uploaded file opened for reading.
+type File interface {
+    io.Reader
+    io.ReaderAt
+    io.Seeker
+    io.Closer
+}
+
+type memoryFile struct {
+    *bytes.Reader
+}
+
+func (memoryFile) Close() error {
+    return nil
+}
+
+func (fh *FileHeader) Open() (File, error) {
+    if fh.tmpFile != "" {
+        return os.Open(fh.tmpFile)
+    }
+    return memoryFile{bytes.NewReader(fh.content)}, nil
+}
+
+// RemoveAll deletes the temp files behind the form. The server does this
+// by itself once the handler returns.
+func (mf *MultipartForm) RemoveAll() error {
+    var err error
+    for _, fhs := range mf.File {
+        for _, fh := range fhs {
+            if fh.tmpFile == "" {
+                continue
+            }
+            if e := os.Remove(fh.tmpFile); e != nil && !errors.Is(e, os.ErrNotExist) && err == nil {
+                err = e
+            }
+        }
+    }
+    return err
+}
+
+// cleanups holds what has to run once a request is finished. Copies of a
+// Request made by WithContext share it.
+type cleanups struct {
+    mu  sync.Mutex
+    fns []func()
+}
+
+func (r *Request) addCleanup(fn func()) {
+    if r.cleanups == nil {
+        r.cleanups = &cleanups{}
+    }
+    r.cleanups.mu.Lock()
+    defer r.cleanups.mu.Unlock()
+    r.cleanups.fns = append(r.cleanups.fns, fn)
+}
+
+// Cleanup releases resources tied to the request, like multipart temp
+// files. Servers call it after the handler returns.
+func (r *Request) Cleanup() {
+    if r.cleanups == nil {
+        return
+    }
+    r.cleanups.mu.Lock()
+    fns := r.cleanups.fns
+    r.cleanups.fns = nil
+    r.cleanups.mu.Unlock()
+
+    for _, fn := range fns {
+        fn()
+    }
+}
+
+// MultipartReader returns a reader over the parts of a multipart/form-data
+// body, for handlers that want to stream them instead of calling
+// ParseMultipartForm. A streamed body, see ReadOptions, is read straight
+// off the connection.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+    mediaType, params, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+    if err != nil || mediaType != "multipart/form-data" {
+        return nil, ErrNotMultipart
+    }
+    boundary := params["boundary"]
+    if boundary == "" || len(boundary) > 70 {
+        return nil, errors.New("missing or invalid multipart boundary")
+    }
+    return NewMultipartReader(r.BodyReader(), boundary), nil
+}
+
+// ParseMultipartForm reads a multipart/form-data body into MultipartForm
+// and adds its plain values to Form and PostForm. Temp files are removed
+// when the request is cleaned up.
+func (r *Request) ParseMultipartForm(limits MultipartLimits) error {
+    if r.MultipartForm != nil {
+        return nil
+    }
+    if err := r.ParseForm(); err != nil {
+        return err
+    }
+
+    mr, err := r.MultipartReader()
+    if err != nil {
+        return err
+    }
+
+    form, err := mr.ReadForm(limits)
+    if form != nil {
+        r.addCleanup(func() { form.RemoveAll() })
+    }
+    if err != nil {
+        return err
+    }
+
+    for key, values := range form.Value {
+        r.PostForm[key] = append(r.PostForm[key], values...)
+        r.Form[key] = append(append([]string{}, values...), r.Form[key]...)
+    }
+    r.MultipartForm = form
+    return nil
+}
+
+// MultipartReader reads the parts of a multipart body one by one.
+type MultipartReader struct {
+    br        *bufio.Reader
+    boundary  string
+    // "\r\n--boundary", what ends a part
+    delimiter []byte
+    current   *Part
+    started   bool
+    done      bool
+}
+
+func NewMultipartReader(body io.Reader, boundary string) *MultipartReader {
+    return &MultipartReader{
+        br: bufio.NewReaderSize(body, multipartBufferSize),
+        boundary: boundary,
+        delimiter: []byte("\r\n--" + boundary),
+    }
+}
+
+// Part is one part of a multipart body, reading it yields its content.
+type Part struct {
+    Headers headers.Headers
+    mr      *MultipartReader
+    done    bool
+}
+
+// NextPart skips what is left of the current part and returns the next
+// one, or io.EOF after the last.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+    if mr.done {
+        return nil, io.EOF
+    }
+
+    if !mr.started {
+        if err := mr.skipPreamble(); err != nil {
+            return nil, err
+        }
+        mr.started = true
+    } else if mr.current != nil {
+        if _, err := io.Copy(io.Discard, mr.current); err != nil {
+            return nil, err
+        }
+    }
+    if mr.done {
+        return nil, io.EOF
+    }
+
+    h, err := mr.readPartHeaders()
+    if err != nil {
+        return nil, err
+    }
+    mr.current = &Part{Headers: h, mr: mr}
+    return mr.current, nil
+}
+
+// skipPreamble drops everything up to the first boundary line
+func (mr *MultipartReader) skipPreamble() error {
+    first := "--" + mr.boundary
+    for {
+        line, err := mr.readLine()
+        switch strings.TrimRight(line, " \t\r\n") {
+        case first:
+            if err == nil {
+                return nil
+            }
+        case first + "--":
+            mr.done = true
+            return nil
+        }
+        if errors.Is(err, io.EOF) {
+            return errors.New("multipart body has no boundary")
+        }
+        if err != nil {
+            return err
+        }
+    }
+}
+
+// readLine reads a line of any length, lines that don't fit the buffer
+// can't be boundaries and come back empty
+func (mr *MultipartReader) readLine() (string, error) {
+    line, err := mr.br.ReadSlice('\n')
+    if !errors.Is(err, bufio.ErrBufferFull) {
+        return string(line), err
+    }
+    for errors.Is(err, bufio.ErrBufferFull) {
+        _, err = mr.br.ReadSlice('\n')
+    }
+    return "", err
+}
+
+func (mr *MultipartReader) readPartHeaders() (headers.Headers, error) {
+    h := headers.NewHeaders()
+    size := 0
+    for {
+        line, err := mr.br.ReadSlice('\n')
+        if errors.Is(err, bufio.ErrBufferFull) {
+            return nil, errors.New("multipart part header line too long")
+        }
+        if err != nil {
+            return nil, fmt.Errorf("error while reading multipart part headers: %w", err)
+        }
+        size += len(line)
+        if size > maxPartHeaderSize {
+            return nil, errors.New("multipart part headers too large")
+        }
+
+        line = bytes.TrimRight(line, "\r\n")
+        if len(line) == 0 {
+            return h, nil
+        }
+        if _, _, err := h.Parse(append(line, "\r\n"...)); err != nil {
+            return nil, fmt.Errorf("error while parsing multipart part headers: %w", err)
+        }
+    }
+}
+
+// Read returns the part's content, stopping at the next boundary.
+func (p *Part) Read(b []byte) (int, error) {
+    if p.done {
+        return 0, io.EOF
+    }
+    mr := p.mr
+
+    peek, err := mr.br.Peek(multipartBufferSize)
+    if idx := bytes.Index(peek, mr.delimiter); idx >= 0 {
+        if idx > 0 {
+            n := copy(b, peek[:idx])
+            mr.br.Discard(n)
+            return n, nil
+        }
+        p.done = true
+        return 0, mr.finishPart()
+    }
+    if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+        return 0, err
+    }
+
+    // Hold back enough bytes to hold a delimiter split across reads
+    safe := len(peek) - len(mr.delimiter) + 1
+    if safe <= 0 {
+        if errors.Is(err, io.EOF) {
+            return 0, io.ErrUnexpectedEOF
+        }
+        safe = len(peek)
+    }
+    n := copy(b, peek[:safe])
+    mr.br.Discard(n)
+    return n, nil
+}
+
+// finishPart consumes the delimiter and the rest of its line, noticing
+// whether it was the closing one
+func (mr *MultipartReader) finishPart() error {
+    mr.br.Discard(len(mr.delimiter))
+    line, err := mr.readLine()
+    if err != nil && !(errors.Is(err, io.EOF) && strings.HasPrefix(line, "--")) {
+        return io.ErrUnexpectedEOF
+    }
+    if strings.HasPrefix(line, "--") {
+        mr.done = true
+    }
+    return io.EOF
+}
+
+// FormName returns the name parameter of the part's Content-Disposition.
+func (p *Part) FormName() string {
+    _, params, err := mime.ParseMediaType(p.Headers.Get("Content-Disposition"))
+    if err != nil {
+        return ""
+    }
+    return params["name"]
+}
+
+// FileName returns the base of the part's filename parameter, "" for
+// parts that aren't files.
+func (p *Part) FileName() string {
+    _, params, err := mime.ParseMediaType(p.Headers.Get("Content-Disposition"))
+    if err != nil {
+        return ""
+    }
+    name, ok := params["filename"]
+    if !ok {
+        return ""
+    }
+    name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+    if name == "." || name == "/" {
+        return "unnamed"
+    }
+    return name
+}
+
+// ReadForm reads every part into a MultipartForm. On error the form read
+// so far is still returned so its temp files can be removed.
+func (mr *MultipartReader) ReadForm(limits MultipartLimits) (*MultipartForm, error) {
+    if limits.MaxMemory == 0 {
+        limits.MaxMemory = DefaultMaxMemory
+    }
+    if limits.MaxPartSize == 0 {
+        limits.MaxPartSize = DefaultMaxPartSize
+    }
+    if limits.MaxTotalSize == 0 {
+        limits.MaxTotalSize = DefaultMaxUploadSize
+    }
+    if limits.MaxParts == 0 {
+        limits.MaxParts = DefaultMaxParts
+    }
+
+    form := &MultipartForm{Value: Query{}, File: map[string][]*FileHeader{}}
+    memoryLeft := limits.MaxMemory
+    totalLeft := limits.MaxTotalSize
+
+    for parts := 0; ; parts++ {
+        p, err := mr.NextPart()
+        if errors.Is(err, io.EOF) {
+            return form, nil
+        }
+        if err != nil {
+            return form, err
+        }
+        if parts == limits.MaxParts {
+            return form, ErrTooManyParts
+        }
+
+        name := p.FormName()
+        if name == "" {
+            continue
+        }
+
+        // Read one byte past the limits to tell whether they were hit
+        limit := min(limits.MaxPartSize, totalLeft)
+        buf := &bytes.Buffer{}
+        filename := p.FileName()
+        if filename == "" {
+            n, err := io.CopyN(buf, p, min(memoryLeft, limit) + 1)
+            if err != nil && !errors.Is(err, io.EOF) {
+                return form, err
+            }
+            if n > limit {
+                return form, limitError(limits, totalLeft)
+            }
+            if n > memoryLeft {
+                return form, ErrValuesTooLarge
+            }
+            memoryLeft -= n
+            totalLeft -= n
+            form.Value.Add(name, buf.String())
+            continue
+        }
+
+        fh := &FileHeader{Filename: filename, Headers: p.Headers}
+        n, err := io.CopyN(buf, p, min(memoryLeft, limit) + 1)
+        if err != nil && !errors.Is(err, io.EOF) {
+            return form, err
+        }
+        if n > limit {
+            return form, limitError(limits, totalLeft)
+        }
+
+        if n > memoryLeft {
+            // Too big for memory, the rest goes straight to disk
+            f, err := os.CreateTemp("", "multipart-")
+            if err != nil {
+                return form, err
+            }
+            fh.tmpFile = f.Name()
+            form.File[name] = append(form.File[name], fh)
+
+            written, err := io.Copy(f, io.MultiReader(buf, io.LimitReader(p, limit + 1 - n)))
+            if closeErr := f.Close(); err == nil {
+                err = closeErr
+            }
+            if err != nil {
+                return form, err
+            }
+            if written > limit {
+                return form, limitError(limits, totalLeft)
+            }
+            n = written
+        } else {
+            fh.content = buf.Bytes()
+            memoryLeft -= n
+            form.File[name] = append(form.File[name], fh)
+        }
+
+        fh.Size = n
+        totalLeft -= n
+    }
+}
+
+func limitError(limits MultipartLimits, totalLeft int64) error {
+    if totalLeft < limits.MaxPartSize {
+        return ErrMultipartTooLarge
+    }
+    return ErrPartTooLarge
+}
@@ -1,11 +1,16 @@
 package request
 
 import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
     "io"
     "strings"
     "fmt"
     "errors"
     "strconv"
+    "github.com/aringq10/http-go-server/internal/cookie"
     "github.com/aringq10/http-go-server/internal/headers"
 )
 
@@ -34,9 +39,51 @@ var httpMethods = map[string]struct{}{
 
 type Request struct {
     RequestLine RequestLine
+    URL URL
     Headers headers.Headers
     Body []byte
+    // Form and PostForm are filled by ParseForm
+    Form Query
+    PostForm Query
+    // MultipartForm is filled by ParseMultipartForm
+    MultipartForm *MultipartForm
     state int
+    ctx context.Context
+    buffered []byte
+    cleanups *cleanups
+    id string
+    // body is set when the body is left on the reader, see ReadOptions
+    body *body
+    maxBodySize int64
+    streamBody bool
+}
+
+// ReadOptions changes how RequestFromReaderOptions reads the body.
+type ReadOptions struct {
+    // MaxBodySize fails requests whose Content-Length is larger with
+    // ErrBodyTooLarge before any of the body is read, 0 means no limit
+    MaxBodySize int64
+    // StreamBody stops reading at the end of the headers. The body is then
+    // read through BodyReader, or ReadBody to fill Body.
+    StreamBody bool
+}
+
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// ParseError is returned for requests that failed after their request line
+// and headers were read, e.g. over the body. Request holds what was
+// parsed, enough to pick the format of the error response.
+type ParseError struct {
+    Request *Request
+    Err     error
+}
+
+func (e *ParseError) Error() string {
+    return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+    return e.Err
 }
 
 type RequestLine struct {
@@ -48,17 +95,111 @@ type RequestLine struct {
 func NewRequest() Request {
     req := Request{}
     req.Headers = make(headers.Headers)
+    req.cleanups = &cleanups{}
+    req.id = newRequestID()
     return req
 }
 
+// ID identifies the request in logs and error pages. A sane X-Request-Id
+// sent by the client or a proxy is used as is, otherwise a random one is
+// made up.
+func (r *Request) ID() string {
+    if id := r.Headers.Get("X-Request-Id"); validRequestID(id) {
+        return id
+    }
+    if r.id == "" {
+        r.id = newRequestID()
+    }
+    return r.id
+}
+
+func newRequestID() string {
+    b := make([]byte, 8)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+    if id == "" || len(id) > 128 {
+        return false
+    }
+    for i := 0; i < len(id); i++ {
+        if id[i] <= ' ' || id[i] >= 0x7f {
+            return false
+        }
+    }
+    return true
+}
+
+// Context returns the request's context. It is canceled when the client
+// disconnects, the server shuts down or the handler times out.
+func (r *Request) Context() context.Context {
+    if r.ctx != nil {
+        return r.ctx
+    }
+    return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+    if ctx == nil {
+        panic("nil context")
+    }
+    r2 := *r
+    r2.ctx = ctx
+    return &r2
+}
+
+// ContextReader wraps reader so that reads fail with ctx's error once ctx
+// is done, which stops copying a response body to a client that left.
+func ContextReader(ctx context.Context, reader io.Reader) io.Reader {
+    return contextReader{ctx, reader}
+}
+
+type contextReader struct {
+    ctx context.Context
+    r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+    if err := cr.ctx.Err(); err != nil {
+        return 0, err
+    }
+    return cr.r.Read(p)
+}
+
+// Cookies returns the cookies sent in the Cookie header by name.
+func (r *Request) Cookies() map[string]string {
+    return cookie.Parse(r.Headers.Get("Cookie"))
+}
+
+// Cookie returns the value of the named cookie and whether it was sent.
+func (r *Request) Cookie(name string) (string, bool) {
+    value, ok := r.Cookies()[name]
+    return value, ok
+}
+
 func validHttpMethod(method string) bool {
     _, ok := httpMethods[method]
     return ok
 }
 
+// RequestFromReader reads a request with its whole body into Body.
 func RequestFromReader(reader io.Reader) (*Request, error) {
+    return RequestFromReaderOptions(reader, ReadOptions{})
+}
+
+func RequestFromReaderOptions(reader io.Reader, opts ReadOptions) (*Request, error) {
     req := NewRequest()
     req.state = requestStateInitialized
+    req.maxBodySize = opts.MaxBodySize
+    req.streamBody = opts.StreamBody
+    fail := func(err error) (*Request, error) {
+        if req.state >= requestStateParsingBody {
+            return nil, &ParseError{Request: &req, Err: err}
+        }
+        return nil, err
+    }
     buf := make([]byte, bufferSize)
     readToIndex := 0 // Index up to which the buffer is filled
 
@@ -80,26 +221,151 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
                 case requestStateParsingHeaders:
                     return nil, errors.New("error: missing end of headers")
                 case requestStateParsingBody:
-                    return nil, errors.New("error: body smaller than reported content length")
+                    return fail(errors.New("error: body smaller than reported content length"))
                 }
                 break
             }
-            return  nil, err
+            return fail(err)
         }
         readToIndex += n
 
         n, err = req.parse(buf[:readToIndex])
         if err != nil {
-            return nil, err
+            return fail(err)
         }
         tmpBuf := buf[n:]
         copy(buf, tmpBuf)
         readToIndex -= n
+
+        if opts.StreamBody && req.state == requestStateParsingBody {
+            length, err := req.contentLength()
+            if err != nil {
+                return fail(err)
+            }
+            req.body = &body{
+                buffered: append([]byte{}, buf[:readToIndex]...),
+                conn: reader,
+                remaining: length,
+            }
+            req.state = requestStateDone
+            return &req, nil
+        }
+    }
+
+    if readToIndex > 0 {
+        req.buffered = append([]byte{}, buf[:readToIndex]...)
     }
 
     return &req, nil
 }
 
+// contentLength returns the length of the body the headers announce
+func (r *Request) contentLength() (int64, error) {
+    lengthStr := r.Headers.Get("Content-Length")
+    if lengthStr == "" {
+        return 0, nil
+    }
+    length, err := strconv.ParseInt(lengthStr, 10, 64)
+    if err != nil || length < 0 {
+        return 0, fmt.Errorf("error: invalid content length %q", lengthStr)
+    }
+    return length, nil
+}
+
+// Buffered returns the bytes RequestFromReader read past the end of the
+// request, e.g. the start of the next protocol after an Upgrade. With a
+// streamed body it's what was read along with the headers and not
+// consumed yet, so the unread part of the body comes first.
+func (r *Request) Buffered() []byte {
+    if r.body != nil {
+        return r.body.buffered
+    }
+    return r.buffered
+}
+
+// BodyReader returns the body. Streamed bodies are read straight off the
+// connection, only once, others are read from Body.
+func (r *Request) BodyReader() io.Reader {
+    if r.body != nil {
+        return r.body
+    }
+    return bytes.NewReader(r.Body)
+}
+
+// ReadBody reads a streamed body into Body, failing with ErrBodyTooLarge
+// when it's larger than maxSize. Buffered bodies are only checked against
+// maxSize.
+func (r *Request) ReadBody(maxSize int) error {
+    if r.body == nil {
+        if len(r.Body) > maxSize {
+            return ErrBodyTooLarge
+        }
+        return nil
+    }
+    if r.body.remaining > int64(maxSize) {
+        return ErrBodyTooLarge
+    }
+
+    body, err := io.ReadAll(r.body)
+    if err != nil {
+        return err
+    }
+    r.Body = body
+    r.buffered = r.body.buffered
+    r.body = nil
+    return nil
+}
+
+// OnBodyRead calls fn once a streamed body has been read to its end, right
+// away for any other. The server watches the connection from then on.
+func (r *Request) OnBodyRead(fn func()) {
+    if r.body == nil || r.body.remaining == 0 {
+        fn()
+        return
+    }
+    r.body.onEOF = fn
+}
+
+// body reads a streamed body, first from what was read along with the
+// headers and then from the connection
+type body struct {
+    buffered  []byte
+    conn      io.Reader
+    remaining int64
+    onEOF     func()
+}
+
+func (b *body) Read(p []byte) (int, error) {
+    if b.remaining == 0 {
+        return 0, io.EOF
+    }
+    if int64(len(p)) > b.remaining {
+        p = p[:b.remaining]
+    }
+
+    var n int
+    var err error
+    if len(b.buffered) > 0 {
+        n = copy(p, b.buffered)
+        b.buffered = b.buffered[n:]
+    } else {
+        n, err = b.conn.Read(p)
+    }
+    b.remaining -= int64(n)
+
+    if b.remaining == 0 {
+        if b.onEOF != nil {
+            b.onEOF()
+            b.onEOF = nil
+        }
+        return n, nil
+    }
+    if errors.Is(err, io.EOF) {
+        return n, io.ErrUnexpectedEOF
+    }
+    return n, err
+}
+
 func (r *Request) parse(data []byte) (totalBytesParsed int, err error) {
     for r.state != requestStateDone {
         n, err := r.parseSingle(data[totalBytesParsed:])
@@ -125,6 +391,10 @@ func (r *Request) parseSingle(data []byte) (n int, err error) {
             return 0, fmt.Errorf("error while parsing request line: %v", err.Error())
         }
         if n != 0 {
+            r.URL, err = ParseTarget(r.RequestLine.Method, r.RequestLine.RequestTarget)
+            if err != nil {
+                return 0, fmt.Errorf("error while parsing request target: %v", err.Error())
+            }
             r.state = requestStateParsingHeaders
         }
         return
@@ -156,6 +426,13 @@ func (r *Request) parseSingle(data []byte) (n int, err error) {
             r.state = requestStateDone
             return 0, nil
         }
+        if r.maxBodySize > 0 && int64(length) > r.maxBodySize {
+            return 0, ErrBodyTooLarge
+        }
+        if r.streamBody {
+            // Left on the reader for BodyReader
+            return 0, nil
+        }
 
         if len(r.Body) < length && len(data) == 0 {
             return 0, nil
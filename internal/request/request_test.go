@@ -1,7 +1,14 @@
 package request
 
 import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
     "io"
+    "mime/multipart"
+    "os"
+    "strings"
     "testing"
 
     "github.com/stretchr/testify/assert"
@@ -231,3 +238,408 @@ func TestRequestLineParse(t *testing.T) {
     r, err = RequestFromReader(reader)
     require.Error(t, err)
 }
+
+func TestRequestTargetParse(t *testing.T) {
+    // Test: Origin form with query
+    reader := &chunkReader{
+        data:            "GET /vid%65o/../video/a%20b.mp4?x=1&tag=a&tag=b+c HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+        numBytesPerRead: 5,
+    }
+    r, err := RequestFromReader(reader)
+    require.NoError(t, err)
+    require.NotNil(t, r)
+    assert.Equal(t, TargetOrigin, r.URL.Form)
+    assert.Equal(t, "/video/a b.mp4", r.URL.Path)
+    assert.Equal(t, "/vid%65o/../video/a%20b.mp4", r.URL.RawPath)
+    assert.Equal(t, "x=1&tag=a&tag=b+c", r.URL.RawQuery)
+    assert.Equal(t, "1", r.URL.Query.Get("x"))
+    assert.Equal(t, []string{"a", "b c"}, r.URL.Query["tag"])
+
+    // Test: Traversal stays below the root
+    u, err := ParseTarget("GET", "/video/../../etc/passwd")
+    require.NoError(t, err)
+    assert.Equal(t, "/etc/passwd", u.Path)
+
+    // Test: Trailing slash is kept
+    u, err = ParseTarget("GET", "/assets//dir/")
+    require.NoError(t, err)
+    assert.Equal(t, "/assets/dir/", u.Path)
+
+    // Test: Absolute form
+    u, err = ParseTarget("GET", "http://localhost:42069/coffee?size=big")
+    require.NoError(t, err)
+    assert.Equal(t, TargetAbsolute, u.Form)
+    assert.Equal(t, "http", u.Scheme)
+    assert.Equal(t, "localhost:42069", u.Host)
+    assert.Equal(t, "/coffee", u.Path)
+    assert.Equal(t, "big", u.Query.Get("size"))
+
+    // Test: Absolute form without a path
+    u, err = ParseTarget("GET", "http://localhost:42069")
+    require.NoError(t, err)
+    assert.Equal(t, "/", u.Path)
+
+    // Test: Authority form
+    u, err = ParseTarget("CONNECT", "localhost:42069")
+    require.NoError(t, err)
+    assert.Equal(t, TargetAuthority, u.Form)
+    assert.Equal(t, "localhost:42069", u.Host)
+
+    // Test: Authority form without a port
+    _, err = ParseTarget("CONNECT", "localhost")
+    require.Error(t, err)
+
+    // Test: Asterisk form
+    u, err = ParseTarget("OPTIONS", "*")
+    require.NoError(t, err)
+    assert.Equal(t, TargetAsterisk, u.Form)
+
+    // Test: Asterisk form for anything but OPTIONS
+    _, err = ParseTarget("GET", "*")
+    require.Error(t, err)
+
+    // Test: Relative target
+    _, err = ParseTarget("GET", "coffee")
+    require.Error(t, err)
+
+    // Test: Malformed percent-encoding
+    reader = &chunkReader{
+        data:            "GET /vid%6 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+        numBytesPerRead: 6,
+    }
+    _, err = RequestFromReader(reader)
+    require.Error(t, err)
+
+    // Test: Malformed percent-encoding in the query
+    _, err = ParseTarget("GET", "/coffee?size=%zz")
+    require.Error(t, err)
+}
+
+func formRequest(t *testing.T, method string, target string, contentType string, body string) *Request {
+    raw := fmt.Sprintf("%v %v HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: %d\r\n", method, target, len(body))
+    if contentType != "" {
+        raw += "Content-Type: " + contentType + "\r\n"
+    }
+    r, err := RequestFromReader(&chunkReader{data: raw + "\r\n" + body, numBytesPerRead: 64})
+    require.NoError(t, err)
+    return r
+}
+
+func TestParseForm(t *testing.T) {
+    // Test: Body and query values are merged, body first
+    r := formRequest(t, "POST", "/submit?tag=query&page=2", "application/x-www-form-urlencoded; charset=utf-8", "tag=body&name=lane+wagner&tag=%F0%9F%A6%AB")
+    require.NoError(t, r.ParseForm())
+    assert.Equal(t, []string{"body", "🦫", "query"}, r.Form["tag"])
+    assert.Equal(t, "lane wagner", r.FormValue("name"))
+    assert.Equal(t, "2", r.FormValue("page"))
+    assert.Equal(t, "", r.PostFormValue("page"))
+    assert.Equal(t, []string{"body", "🦫"}, r.PostForm["tag"])
+
+    // Test: Other content types only get the query
+    r = formRequest(t, "POST", "/submit?page=2", "application/json", "{\"tag\":\"body\"}")
+    require.NoError(t, r.ParseForm())
+    assert.Equal(t, Query{"page": {"2"}}, r.Form)
+    assert.Empty(t, r.PostForm)
+
+    // Test: GET bodies are ignored
+    r = formRequest(t, "GET", "/submit", "application/x-www-form-urlencoded", "tag=body")
+    require.NoError(t, r.ParseForm())
+    assert.False(t, r.Form.Has("tag"))
+
+    // Test: Malformed encoding
+    r = formRequest(t, "POST", "/submit", "application/x-www-form-urlencoded", "tag=%zz")
+    assert.Error(t, r.ParseForm())
+
+    // Test: Body over the limit
+    r = formRequest(t, "POST", "/submit", "application/x-www-form-urlencoded", "tag=0123456789")
+    assert.ErrorIs(t, r.ParseFormLimit(8), ErrFormTooLarge)
+}
+
+func multipartBody(t *testing.T, files map[string]string, values map[string]string) (string, string) {
+    buf := &bytes.Buffer{}
+    mw := multipart.NewWriter(buf)
+    for name, value := range values {
+        require.NoError(t, mw.WriteField(name, value))
+    }
+    for filename, content := range files {
+        fw, err := mw.CreateFormFile("upload", filename)
+        require.NoError(t, err)
+        fw.Write([]byte(content))
+    }
+    require.NoError(t, mw.Close())
+    return mw.FormDataContentType(), buf.String()
+}
+
+func TestMultipart(t *testing.T) {
+    big := strings.Repeat("0123456789", 3000)
+    contentType, body := multipartBody(t,
+        map[string]string{"small.txt": "hello", "../../big.bin": big},
+        map[string]string{"title": "holiday", "tags": "a b"},
+    )
+
+    // Test: Values, in memory files and spilled files
+    r := formRequest(t, "POST", "/upload?title=query", contentType, body)
+    require.NoError(t, r.ParseMultipartForm(MultipartLimits{MaxMemory: 1024}))
+    assert.Equal(t, []string{"holiday", "query"}, r.Form["title"])
+    assert.Equal(t, "a b", r.PostFormValue("tags"))
+
+    files := map[string]*FileHeader{}
+    for _, fh := range r.MultipartForm.File["upload"] {
+        files[fh.Filename] = fh
+    }
+    require.Contains(t, files, "small.txt")
+    require.Contains(t, files, "big.bin")
+    assert.Empty(t, files["small.txt"].tmpFile)
+    assert.Equal(t, int64(len(big)), files["big.bin"].Size)
+    tmpFile := files["big.bin"].tmpFile
+    require.NotEmpty(t, tmpFile)
+
+    f, err := files["big.bin"].Open()
+    require.NoError(t, err)
+    content, err := io.ReadAll(f)
+    f.Close()
+    require.NoError(t, err)
+    assert.Equal(t, big, string(content))
+
+    // Test: Cleanup removes temp files, also through a context copy
+    r.WithContext(context.Background()).Cleanup()
+    _, err = os.Stat(tmpFile)
+    assert.True(t, os.IsNotExist(err))
+
+    // Test: Limits
+    r = formRequest(t, "POST", "/upload", contentType, body)
+    assert.ErrorIs(t, r.ParseMultipartForm(MultipartLimits{MaxPartSize: 1000}), ErrPartTooLarge)
+    r = formRequest(t, "POST", "/upload", contentType, body)
+    assert.ErrorIs(t, r.ParseMultipartForm(MultipartLimits{MaxTotalSize: 1000}), ErrMultipartTooLarge)
+    r = formRequest(t, "POST", "/upload", contentType, body)
+    assert.ErrorIs(t, r.ParseMultipartForm(MultipartLimits{MaxParts: 2}), ErrTooManyParts)
+
+    // Test: Values count against the memory limit, they can't spill
+    fields := map[string]string{}
+    for i := 0; i < 5; i++ {
+        fields[fmt.Sprintf("field%d", i)] = strings.Repeat("v", 400)
+    }
+    valuesType, valuesBody := multipartBody(t, nil, fields)
+    r = formRequest(t, "POST", "/upload", valuesType, valuesBody)
+    assert.ErrorIs(t, r.ParseMultipartForm(MultipartLimits{MaxMemory: 1024}), ErrValuesTooLarge)
+    r = formRequest(t, "POST", "/upload", valuesType, valuesBody)
+    require.NoError(t, r.ParseMultipartForm(MultipartLimits{MaxMemory: 2000}))
+    assert.Len(t, r.MultipartForm.Value, 5)
+
+    // Test: Not multipart
+    r = formRequest(t, "POST", "/upload", "text/plain", "hi")
+    assert.ErrorIs(t, r.ParseMultipartForm(MultipartLimits{}), ErrNotMultipart)
+
+    // Test: Streaming reader with a boundary split across reads
+    raw := "preamble\r\n--XyZ\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nfirst\r\n--X\r\n" +
+        "--XyZ\r\nContent-Disposition: form-data; name=\"b\"; filename=\"C:\\\\docs\\\\b.txt\"\r\nContent-Type: text/plain\r\n\r\n\r\n--XyZ--\r\n"
+    mr := NewMultipartReader(&chunkReader{data: raw, numBytesPerRead: 1}, "XyZ")
+    p, err := mr.NextPart()
+    require.NoError(t, err)
+    assert.Equal(t, "a", p.FormName())
+    content, err = io.ReadAll(p)
+    require.NoError(t, err)
+    assert.Equal(t, "first\r\n--X", string(content))
+    p, err = mr.NextPart()
+    require.NoError(t, err)
+    assert.Equal(t, "b.txt", p.FileName())
+    assert.Equal(t, "text/plain", p.Headers.Get("Content-Type"))
+    _, err = mr.NextPart()
+    assert.ErrorIs(t, err, io.EOF)
+
+    // Test: Truncated body
+    mr = NewMultipartReader(strings.NewReader("--XyZ\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nno end"), "XyZ")
+    p, err = mr.NextPart()
+    require.NoError(t, err)
+    _, err = io.ReadAll(p)
+    assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestDecodeJSON(t *testing.T) {
+    type signup struct {
+        Name string `json:"name"`
+        Age  int    `json:"age"`
+    }
+
+    // Test: Valid body, +json types are fine too
+    r := formRequest(t, "POST", "/users", "application/vnd.api+json; charset=utf-8", `{"name": "lane", "age": 30}`)
+    v, err := DecodeJSON[signup](r)
+    require.NoError(t, err)
+    assert.Equal(t, signup{"lane", 30}, v)
+
+    status := func(err error) int {
+        var bodyErr *BodyError
+        require.ErrorAs(t, err, &bodyErr)
+        return bodyErr.Status
+    }
+
+    // Test: Wrong media type
+    _, err = DecodeJSON[signup](formRequest(t, "POST", "/users", "text/plain", `{"name": "lane"}`))
+    assert.Equal(t, 415, status(err))
+
+    // Test: Too large
+    _, err = DecodeJSONLimit[signup](formRequest(t, "POST", "/users", "application/json", `{"name": "lane"}`), 8)
+    assert.Equal(t, 413, status(err))
+
+    // Test: Unknown field
+    _, err = DecodeJSON[signup](formRequest(t, "POST", "/users", "application/json", `{"name": "lane", "admin": true}`))
+    assert.Equal(t, 400, status(err))
+    assert.Equal(t, `unknown field "admin"`, err.Error())
+
+    // Test: Wrong type
+    _, err = DecodeJSON[signup](formRequest(t, "POST", "/users", "application/json", `{"age": "thirty"}`))
+    assert.Equal(t, `field "age" must be int`, err.Error())
+
+    // Test: Malformed, empty and trailing data
+    _, err = DecodeJSON[signup](formRequest(t, "POST", "/users", "application/json", `{"name": }`))
+    assert.Equal(t, 400, status(err))
+    _, err = DecodeJSON[signup](formRequest(t, "POST", "/users", "application/json", ""))
+    assert.Equal(t, 400, status(err))
+    _, err = DecodeJSON[signup](formRequest(t, "POST", "/users", "application/json", `{"name": "lane"} {}`))
+    assert.Equal(t, 400, status(err))
+}
+
+func TestContextReader(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    reader := ContextReader(ctx, strings.NewReader("hello world"))
+
+    // Test: Reads pass through while the context is live
+    buf := make([]byte, 5)
+    n, err := reader.Read(buf)
+    require.NoError(t, err)
+    assert.Equal(t, "hello", string(buf[:n]))
+
+    // Test: Reads fail once it's canceled
+    cancel()
+    _, err = reader.Read(buf)
+    assert.ErrorIs(t, err, context.Canceled)
+}
+
+// streamRequest reads the headers of a request whose body stays on reader
+func streamRequest(t *testing.T, reader io.Reader) *Request {
+    r, err := RequestFromReaderOptions(reader, ReadOptions{StreamBody: true})
+    require.NoError(t, err)
+    return r
+}
+
+func TestStreamBody(t *testing.T) {
+    raw := "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\nNEXT"
+
+    // Test: Body left on the reader, at every read size
+    for i := 1; i <= len(raw); i++ {
+        r := streamRequest(t, &chunkReader{data: raw, numBytesPerRead: i})
+        assert.Empty(t, r.Body)
+        read := 0
+        r.OnBodyRead(func() { read++ })
+        body, err := io.ReadAll(r.BodyReader())
+        require.NoError(t, err)
+        assert.Equal(t, "hello world!\n", string(body))
+        assert.Equal(t, 1, read)
+    }
+
+    // Test: Bytes past the body stay buffered, the unread body first
+    r := streamRequest(t, strings.NewReader(raw))
+    assert.Equal(t, "hello world!\nNEXT", string(r.Buffered()))
+    body := make([]byte, 6)
+    _, err := io.ReadFull(r.BodyReader(), body)
+    require.NoError(t, err)
+    assert.Equal(t, "world!\nNEXT", string(r.Buffered()))
+    require.NoError(t, r.ReadBody(100))
+    assert.Equal(t, "world!\n", string(r.Body))
+    assert.Equal(t, "NEXT", string(r.Buffered()))
+
+    // Test: No body
+    r = streamRequest(t, strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+    called := false
+    r.OnBodyRead(func() { called = true })
+    assert.True(t, called)
+    body, err = io.ReadAll(r.BodyReader())
+    require.NoError(t, err)
+    assert.Empty(t, body)
+
+    // Test: Body cut short
+    r = streamRequest(t, strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 20\r\n\r\nshort"))
+    _, err = io.ReadAll(r.BodyReader())
+    assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+    // Test: ReadBody refuses bodies past its limit without reading them
+    r = streamRequest(t, strings.NewReader(raw))
+    assert.ErrorIs(t, r.ReadBody(5), ErrBodyTooLarge)
+    require.NoError(t, r.ReadBody(13))
+    assert.Equal(t, "hello world!\n", string(r.Body))
+
+    // Test: Forms and JSON read streamed bodies
+    form := "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 9\r\n\r\nname=lane"
+    r = streamRequest(t, strings.NewReader(form))
+    assert.Equal(t, "lane", r.PostFormValue("name"))
+    r = streamRequest(t, strings.NewReader(form))
+    assert.ErrorIs(t, r.ParseFormLimit(4), ErrFormTooLarge)
+    r = streamRequest(t, strings.NewReader("POST /users HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/json\r\nContent-Length: 16\r\n\r\n{\"name\": \"lane\"}"))
+    user, err := DecodeJSON[struct{ Name string `json:"name"` }](r)
+    require.NoError(t, err)
+    assert.Equal(t, "lane", user.Name)
+}
+
+func TestMaxBodySize(t *testing.T) {
+    raw := "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\n"
+
+    // Test: Bodies over the limit are refused, streamed or not
+    for _, stream := range []bool{false, true} {
+        _, err := RequestFromReaderOptions(strings.NewReader(raw), ReadOptions{MaxBodySize: 12, StreamBody: stream})
+        assert.ErrorIs(t, err, ErrBodyTooLarge)
+        _, err = RequestFromReaderOptions(strings.NewReader(raw), ReadOptions{MaxBodySize: 13, StreamBody: stream})
+        assert.NoError(t, err)
+    }
+
+    // Test: The parsed request comes with the error
+    _, err := RequestFromReaderOptions(strings.NewReader(raw), ReadOptions{MaxBodySize: 12})
+    var parseErr *ParseError
+    require.ErrorAs(t, err, &parseErr)
+    assert.Equal(t, "POST", parseErr.Request.RequestLine.Method)
+    assert.Equal(t, "13", parseErr.Request.Headers.Get("Content-Length"))
+
+    // Test: Errors before the headers are done come alone
+    _, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nBroken header\r\n\r\n"))
+    assert.Error(t, err)
+    assert.False(t, errors.As(err, &parseErr))
+
+    // Test: The limit applies before the body arrives
+    pr, pw := io.Pipe()
+    defer pw.Close()
+    go pw.Write([]byte("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 1000000000\r\n\r\n"))
+    _, err = RequestFromReaderOptions(pr, ReadOptions{MaxBodySize: 1 << 20})
+    assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestMultipartStream(t *testing.T) {
+    contentType, body := multipartBody(t,
+        map[string]string{"first.txt": "uploaded"},
+        nil,
+    )
+    head := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: %v\r\nContent-Length: %d\r\n\r\n", contentType, len(body))
+
+    // Test: Parts are read as they arrive, before the body has been sent
+    // in full
+    split := strings.Index(body, "uploaded") + 4
+    pr, pw := io.Pipe()
+    go func() {
+        pw.Write([]byte(head))
+        pw.Write([]byte(body[:split]))
+    }()
+    r := streamRequest(t, pr)
+    mr, err := r.MultipartReader()
+    require.NoError(t, err)
+    p, err := mr.NextPart()
+    require.NoError(t, err)
+    assert.Equal(t, "first.txt", p.FileName())
+
+    go func() {
+        pw.Write([]byte(body[split:]))
+        pw.Close()
+    }()
+    content, err := io.ReadAll(p)
+    require.NoError(t, err)
+    assert.Equal(t, "uploaded", string(content))
+    _, err = mr.NextPart()
+    assert.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,217 @@
+package request
+
+import (
+    "errors"
+    "fmt"
+    "path"
+    "strconv"
+    "strings"
+)
+
+// Request target forms from RFC 9112 section 3.2
+const (
+    TargetOrigin int = iota
+    TargetAbsolute
+    TargetAuthority
+    TargetAsterisk
+)
+
+// URL is the parsed request target. Path is percent-decoded and cleaned of
+// dot segments, RawPath is the path exactly as the client sent it.
+type URL struct {
+    Form     int
+    Scheme   string
+    Host     string
+    Path     string
+    RawPath  string
+    RawQuery string
+    Query    Query
+    Fragment string
+}
+
+// Query holds every value sent for each query parameter.
+type Query map[string][]string
+
+func (q Query) Get(key string) string {
+    values := q[key]
+    if len(values) == 0 {
+        return ""
+    }
+    return values[0]
+}
+
+func (q Query) Has(key string) bool {
+    _, ok := q[key]
+    return ok
+}
+
+func (q Query) Add(key string, value string) {
+    q[key] = append(q[key], value)
+}
+
+func ParseTarget(method string, target string) (URL, error) {
+    if target == "" {
+        return URL{}, errors.New("empty request target")
+    }
+
+    if target == "*" {
+        if method != "OPTIONS" {
+            return URL{}, errors.New("asterisk request target is only allowed for OPTIONS")
+        }
+        return URL{Form: TargetAsterisk, Path: "*", RawPath: "*", Query: Query{}}, nil
+    }
+
+    if method == "CONNECT" {
+        if err := validAuthority(target); err != nil {
+            return URL{}, err
+        }
+        return URL{Form: TargetAuthority, Host: target, Query: Query{}}, nil
+    }
+
+    u := URL{Form: TargetOrigin}
+    rest := target
+
+    if !strings.HasPrefix(target, "/") {
+        scheme, afterScheme, ok := strings.Cut(target, "://")
+        if !ok || !validScheme(scheme) {
+            return URL{}, fmt.Errorf("invalid request target - \"%v\"", target)
+        }
+        u.Form = TargetAbsolute
+        u.Scheme = strings.ToLower(scheme)
+
+        end := strings.IndexAny(afterScheme, "/?#")
+        if end == -1 {
+            end = len(afterScheme)
+        }
+        u.Host = afterScheme[:end]
+        if u.Host == "" {
+            return URL{}, fmt.Errorf("missing host in request target - \"%v\"", target)
+        }
+        rest = afterScheme[end:]
+    }
+
+    rest, fragment, hasFragment := strings.Cut(rest, "#")
+    if hasFragment {
+        decoded, err := unescape(fragment, false)
+        if err != nil {
+            return URL{}, err
+        }
+        u.Fragment = decoded
+    }
+
+    rawPath, rawQuery, _ := strings.Cut(rest, "?")
+    if rawPath == "" {
+        rawPath = "/"
+    }
+    u.RawPath = rawPath
+    u.RawQuery = rawQuery
+
+    decodedPath, err := unescape(rawPath, false)
+    if err != nil {
+        return URL{}, err
+    }
+    if strings.IndexByte(decodedPath, 0) != -1 {
+        return URL{}, errors.New("request path contains a NUL byte")
+    }
+    u.Path = cleanPath(decodedPath)
+
+    u.Query, err = ParseQuery(rawQuery)
+    if err != nil {
+        return URL{}, err
+    }
+
+    return u, nil
+}
+
+// ParseQuery parses a urlencoded query string, '+' stands for a space.
+func ParseQuery(rawQuery string) (Query, error) {
+    q := Query{}
+    if rawQuery == "" {
+        return q, nil
+    }
+
+    for _, pair := range strings.Split(rawQuery, "&") {
+        if pair == "" {
+            continue
+        }
+        key, value, _ := strings.Cut(pair, "=")
+        key, err := unescape(key, true)
+        if err != nil {
+            return nil, err
+        }
+        value, err = unescape(value, true)
+        if err != nil {
+            return nil, err
+        }
+        q.Add(key, value)
+    }
+
+    return q, nil
+}
+
+func unescape(s string, plusIsSpace bool) (string, error) {
+    if !strings.ContainsAny(s, "%+") {
+        return s, nil
+    }
+
+    b := make([]byte, 0, len(s))
+    for i := 0; i < len(s); i++ {
+        switch {
+        case s[i] == '%':
+            if i + 2 >= len(s) {
+                return "", fmt.Errorf("malformed percent-encoding - \"%v\"", s)
+            }
+            v, err := strconv.ParseUint(s[i + 1:i + 3], 16, 8)
+            if err != nil {
+                return "", fmt.Errorf("malformed percent-encoding - \"%v\"", s)
+            }
+            b = append(b, byte(v))
+            i += 2
+        case s[i] == '+' && plusIsSpace:
+            b = append(b, ' ')
+        default:
+            b = append(b, s[i])
+        }
+    }
+
+    return string(b), nil
+}
+
+// cleanPath removes dot segments and duplicate slashes, keeping a trailing
+// slash since it tells a directory apart from a file
+func cleanPath(p string) string {
+    cleaned := path.Clean("/" + p)
+    if strings.HasSuffix(p, "/") && cleaned != "/" {
+        cleaned += "/"
+    }
+    return cleaned
+}
+
+func validScheme(scheme string) bool {
+    if scheme == "" {
+        return false
+    }
+    for i, c := range scheme {
+        isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+        if isLetter {
+            continue
+        }
+        if i > 0 && ((c >= '0' && c <= '9') || c == '+' || c == '-' || c == '.') {
+            continue
+        }
+        return false
+    }
+    return true
+}
+
+func validAuthority(authority string) error {
+    i := strings.LastIndexByte(authority, ':')
+    if i <= 0 || strings.ContainsAny(authority, "/?#@") {
+        return fmt.Errorf("CONNECT target must be host:port - \"%v\"", authority)
+    }
+    port, err := strconv.Atoi(authority[i + 1:])
+    if err != nil || port < 0 || port > 65535 {
+        return fmt.Errorf("invalid port in CONNECT target - \"%v\"", authority)
+    }
+    return nil
+}
@@ -0,0 +1,144 @@
+package response
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+)
+
+// StrongETag derives a strong validator from the representation's bytes.
+func StrongETag(body []byte) string {
+    sum := sha256.Sum256(body)
+    return fmt.Sprintf("\"%v\"", hex.EncodeToString(sum[:16]))
+}
+
+// WeakETag derives a weak validator from a file's modification time and size.
+func WeakETag(modTime time.Time, size int64) string {
+    return fmt.Sprintf("W/\"%x-%x\"", modTime.UnixNano(), size)
+}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since in the order RFC 9110 section 13.2.2 gives them.
+// It returns 200 if the request should be served normally, otherwise 304
+// or 412. An empty etag or zero lastModified means that validator is
+// unavailable.
+func CheckPreconditions(req *request.Request, etag string, lastModified time.Time) int {
+    method := req.RequestLine.Method
+    safe := method == "GET" || method == "HEAD"
+    lastModified = lastModified.Truncate(time.Second)
+
+    if ifMatch := req.Headers.Get("If-Match"); ifMatch != "" {
+        if !etagListMatches(ifMatch, etag, true) {
+            return 412
+        }
+    } else if since, ok := parseDate(req.Headers.Get("If-Unmodified-Since")); ok && !lastModified.IsZero() {
+        if lastModified.After(since) {
+            return 412
+        }
+    }
+
+    if ifNoneMatch := req.Headers.Get("If-None-Match"); ifNoneMatch != "" {
+        if etagListMatches(ifNoneMatch, etag, false) {
+            if safe {
+                return 304
+            }
+            return 412
+        }
+    } else if since, ok := parseDate(req.Headers.Get("If-Modified-Since")); ok && safe && !lastModified.IsZero() {
+        if !lastModified.After(since) {
+            return 304
+        }
+    }
+
+    return 200
+}
+
+// WritePreconditionFailure answers with the 304 or 412 returned by
+// CheckPreconditions. Validators and caching headers in h are kept, the
+// body and its framing headers are dropped.
+func (w *Writer) WritePreconditionFailure(statusCode int, h headers.Headers) error {
+    h.Remove("Content-Length")
+    h.Remove("Transfer-Encoding")
+    if statusCode == 412 {
+        h.Remove("ETag")
+        h.Remove("Last-Modified")
+        h.Replace("Content-Length", "0")
+    }
+
+    err := w.WriteStatusLine(statusCode)
+    if err != nil {
+        return err
+    }
+    return w.WriteHeaders(h)
+}
+
+// WriteConditionalMessage works like WriteHttpMessage for a buffered
+// body, adding a strong ETag derived from it unless h already has one and
+// answering 304 or 412 when the request's preconditions call for it.
+func (w *Writer) WriteConditionalMessage(req *request.Request, statusCode int, h headers.Headers, body []byte) error {
+    if statusCode != 200 {
+        return w.WriteHttpMessage(statusCode, h, body)
+    }
+
+    etag := h.Get("ETag")
+    if etag == "" {
+        etag = StrongETag(body)
+        h.Replace("ETag", etag)
+    }
+    lastModified, _ := parseDate(h.Get("Last-Modified"))
+
+    if status := CheckPreconditions(req, etag, lastModified); status != 200 {
+        return w.WritePreconditionFailure(status, h)
+    }
+
+    return w.WriteHttpMessage(statusCode, h, body)
+}
+
+// etagListMatches compares etag against a list of entity tags or "*".
+// Strong comparison fails for weak tags, weak comparison ignores the W/.
+func etagListMatches(list string, etag string, strong bool) bool {
+    if strings.TrimSpace(list) == "*" {
+        return etag != ""
+    }
+    if etag == "" {
+        return false
+    }
+
+    for _, candidate := range strings.Split(list, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if strong {
+            if !strings.HasPrefix(candidate, "W/") && !strings.HasPrefix(etag, "W/") && candidate == etag {
+                return true
+            }
+            continue
+        }
+        if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+            return true
+        }
+    }
+
+    return false
+}
+
+// ETagMatchesStrong reports whether an If-Range style entity tag matches
+// etag using strong comparison.
+func ETagMatchesStrong(candidate string, etag string) bool {
+    return etagListMatches(candidate, etag, true) && strings.TrimSpace(candidate) != "*"
+}
+
+func parseDate(s string) (time.Time, bool) {
+    if s == "" {
+        return time.Time{}, false
+    }
+    t, err := http.ParseTime(s)
+    if err != nil {
+        return time.Time{}, false
+    }
+    return t, true
+}
@@ -0,0 +1,144 @@
+package response
+
+import (
+    "bytes"
+    "fmt"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func testRequest(t *testing.T, method string, fields map[string]string) *request.Request {
+    raw := &strings.Builder{}
+    fmt.Fprintf(raw, "%v / HTTP/1.1\r\nHost: localhost:42069\r\n", method)
+    for key, value := range fields {
+        fmt.Fprintf(raw, "%v: %v\r\n", key, value)
+    }
+    raw.WriteString("\r\n")
+    req, err := request.RequestFromReader(strings.NewReader(raw.String()))
+    require.NoError(t, err)
+    return req
+}
+
+func TestCheckPreconditions(t *testing.T) {
+    modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    before := modified.Add(-time.Hour).Format(http.TimeFormat)
+    at := modified.Format(http.TimeFormat)
+    after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+    tests := []struct {
+        name    string
+        method  string
+        fields  map[string]string
+        etag    string
+        want    int
+    }{
+        {"no preconditions", "GET", nil, `"abc"`, 200},
+
+        // Test: If-None-Match, weak comparison and *
+        {"If-None-Match matches", "GET", map[string]string{"If-None-Match": `"abc"`}, `"abc"`, 304},
+        {"If-None-Match in list", "GET", map[string]string{"If-None-Match": `"x", "abc"`}, `"abc"`, 304},
+        {"If-None-Match differs", "GET", map[string]string{"If-None-Match": `"x"`}, `"abc"`, 200},
+        {"If-None-Match weak candidate", "GET", map[string]string{"If-None-Match": `W/"abc"`}, `"abc"`, 304},
+        {"If-None-Match weak etag", "GET", map[string]string{"If-None-Match": `"abc"`}, `W/"abc"`, 304},
+        {"If-None-Match star", "GET", map[string]string{"If-None-Match": "*"}, `"abc"`, 304},
+        {"If-None-Match star without etag", "GET", map[string]string{"If-None-Match": "*"}, "", 200},
+        {"If-None-Match on HEAD", "HEAD", map[string]string{"If-None-Match": `"abc"`}, `"abc"`, 304},
+        {"If-None-Match on unsafe method", "PUT", map[string]string{"If-None-Match": `"abc"`}, `"abc"`, 412},
+        {"If-None-Match star on unsafe method", "PUT", map[string]string{"If-None-Match": "*"}, `"abc"`, 412},
+
+        // Test: If-Match uses strong comparison
+        {"If-Match matches", "PUT", map[string]string{"If-Match": `"abc"`}, `"abc"`, 200},
+        {"If-Match differs", "PUT", map[string]string{"If-Match": `"x"`}, `"abc"`, 412},
+        {"If-Match weak candidate", "PUT", map[string]string{"If-Match": `W/"abc"`}, `"abc"`, 412},
+        {"If-Match weak etag", "PUT", map[string]string{"If-Match": `"abc"`}, `W/"abc"`, 412},
+        {"If-Match star", "PUT", map[string]string{"If-Match": "*"}, `"abc"`, 200},
+        {"If-Match star without etag", "PUT", map[string]string{"If-Match": "*"}, "", 412},
+
+        // Test: Date preconditions
+        {"If-Modified-Since before", "GET", map[string]string{"If-Modified-Since": before}, "", 200},
+        {"If-Modified-Since at", "GET", map[string]string{"If-Modified-Since": at}, "", 304},
+        {"If-Modified-Since after", "GET", map[string]string{"If-Modified-Since": after}, "", 304},
+        {"If-Modified-Since on unsafe method", "POST", map[string]string{"If-Modified-Since": at}, "", 200},
+        {"If-Modified-Since invalid", "GET", map[string]string{"If-Modified-Since": "yesterday"}, "", 200},
+        {"If-Unmodified-Since before", "PUT", map[string]string{"If-Unmodified-Since": before}, "", 412},
+        {"If-Unmodified-Since at", "PUT", map[string]string{"If-Unmodified-Since": at}, "", 200},
+
+        // Test: Entity tags take precedence over dates
+        {"If-None-Match over If-Modified-Since", "GET", map[string]string{"If-None-Match": `"x"`, "If-Modified-Since": after}, `"abc"`, 200},
+        {"If-None-Match match over If-Modified-Since", "GET", map[string]string{"If-None-Match": `"abc"`, "If-Modified-Since": before}, `"abc"`, 304},
+        {"If-Match over If-Unmodified-Since", "PUT", map[string]string{"If-Match": `"abc"`, "If-Unmodified-Since": before}, `"abc"`, 200},
+        {"If-Match failure before If-None-Match", "GET", map[string]string{"If-Match": `"x"`, "If-None-Match": `"abc"`}, `"abc"`, 412},
+        {"If-Unmodified-Since failure before If-Modified-Since", "GET", map[string]string{"If-Unmodified-Since": before, "If-Modified-Since": after}, `"abc"`, 412},
+
+        // Test: A coded ETag only matches once the unencoded tag is offered
+        // next to it, as compress.Middleware does
+        {"coded ETag alone", "GET", map[string]string{"If-None-Match": `"abc-gzip"`}, `"abc"`, 200},
+        {"coded ETag with unencoded tag", "GET", map[string]string{"If-None-Match": `"abc-gzip", "abc"`}, `"abc"`, 304},
+    }
+
+    for _, tt := range tests {
+        req := testRequest(t, tt.method, tt.fields)
+        assert.Equal(t, tt.want, CheckPreconditions(req, tt.etag, modified), tt.name)
+    }
+}
+
+func TestWriteConditionalMessage(t *testing.T) {
+    body := []byte("hello world")
+    etag := StrongETag(body)
+    modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+
+    tests := []struct {
+        name     string
+        method   string
+        fields   map[string]string
+        status   int
+        etag     string
+        want     int
+        wantBody string
+    }{
+        // Test: The ETag derived from the body is sent and matched
+        {"fresh", "GET", nil, 200, "", 200, "hello world"},
+        {"revalidated", "GET", map[string]string{"If-None-Match": etag}, 200, "", 304, ""},
+        {"changed", "GET", map[string]string{"If-None-Match": `"stale"`}, 200, "", 200, "hello world"},
+        {"If-Match failure", "PUT", map[string]string{"If-Match": `"stale"`}, 200, "", 412, ""},
+        {"Last-Modified", "GET", map[string]string{"If-Modified-Since": modified}, 200, "", 304, ""},
+
+        // Test: A given ETag is kept, weak ones match If-None-Match
+        {"given ETag", "GET", map[string]string{"If-None-Match": `"v2"`}, 200, `W/"v2"`, 304, ""},
+
+        // Test: Non-200 responses ignore preconditions
+        {"not found", "GET", map[string]string{"If-None-Match": "*"}, 404, "", 404, "hello world"},
+    }
+
+    for _, tt := range tests {
+        buf := &bytes.Buffer{}
+        h := GetDefaultHeaders(0)
+        h.Set("Last-Modified", modified)
+        if tt.etag != "" {
+            h.Set("ETag", tt.etag)
+        }
+        err := NewWriter(buf).WriteConditionalMessage(testRequest(t, tt.method, tt.fields), tt.status, h, body)
+        require.NoError(t, err, tt.name)
+
+        resp, respBody := readResponse(t, buf)
+        assert.Equal(t, tt.want, resp.StatusCode, tt.name)
+        assert.Equal(t, tt.wantBody, string(respBody), tt.name)
+        switch tt.want {
+        case 200, 304:
+            if tt.etag != "" {
+                assert.Equal(t, tt.etag, resp.Header.Get("ETag"), tt.name)
+            } else if tt.status == 200 {
+                assert.Equal(t, etag, resp.Header.Get("ETag"), tt.name)
+            }
+        case 412:
+            assert.Empty(t, resp.Header.Get("ETag"), tt.name)
+            assert.Equal(t, "0", resp.Header.Get("Content-Length"), tt.name)
+        }
+    }
+}
@@ -0,0 +1,22 @@
+package response
+
+import (
+    "github.com/aringq10/http-go-server/internal/cookie"
+    "github.com/aringq10/http-go-server/internal/headers"
+)
+
+// SetCookie adds c to h as its own Set-Cookie line.
+func SetCookie(h headers.Headers, c *cookie.Cookie) error {
+    value, err := c.Serialize()
+    if err != nil {
+        return err
+    }
+    h.Add("Set-Cookie", value)
+    return nil
+}
+
+// DeleteCookie tells the client to drop the cookie with the given name and
+// path right away.
+func DeleteCookie(h headers.Headers, name string, path string) error {
+    return SetCookie(h, &cookie.Cookie{Name: name, Path: path, MaxAge: -1})
+}
@@ -0,0 +1,33 @@
+package response
+
+import (
+    "testing"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/cookie"
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestSetCookie(t *testing.T) {
+    h := headers.NewHeaders()
+
+    // Test: Each cookie gets its own Set-Cookie line
+    assert.NoError(t, SetCookie(h, &cookie.Cookie{Name: "session", Value: "abc", Path: "/", HttpOnly: true, Secure: true, SameSite: cookie.SameSiteLax}))
+    assert.NoError(t, SetCookie(h, &cookie.Cookie{Name: "theme", Value: "dark", Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)}))
+    assert.Equal(t, []string{
+        "session=abc; Path=/; Secure; HttpOnly; SameSite=Lax",
+        "theme=dark; Expires=Wed, 02 Jan 2030 03:04:05 GMT",
+    }, h.Values("Set-Cookie"))
+
+    // Test: Deleting expires the cookie right away
+    h = headers.NewHeaders()
+    assert.NoError(t, DeleteCookie(h, "session", "/"))
+    assert.Equal(t, []string{"session=; Path=/; Max-Age=0"}, h.Values("Set-Cookie"))
+
+    // Test: Invalid cookies add nothing
+    h = headers.NewHeaders()
+    assert.Error(t, SetCookie(h, &cookie.Cookie{Name: "bad name", Value: "x"}))
+    assert.Error(t, SetCookie(h, &cookie.Cookie{Name: "ok", Value: "semi;colon"}))
+    assert.Empty(t, h.Values("Set-Cookie"))
+}
@@ -0,0 +1,113 @@
+package response
+
+import (
+    "fmt"
+    "html/template"
+    "io"
+    "io/fs"
+    "os"
+    "path"
+    "strconv"
+    "strings"
+
+    "github.com/aringq10/http-go-server/internal/request"
+)
+
+// ErrorPageData is what error page templates are rendered with.
+type ErrorPageData struct {
+    Status    int
+    Title     string
+    Detail    string
+    Method    string
+    Path      string
+    RequestID string
+}
+
+// ErrorPages renders HTML error pages by status code. A page named
+// "404.html" is used for 404 only, "4xx.html" for any 4xx status and
+// "error.html" for everything else. Files starting with "_" are partials
+// available to every page.
+type ErrorPages struct {
+    pages map[string]*template.Template
+}
+
+// LoadErrorPages parses the pages in the root of fsys, e.g. an embed.FS
+// sub-tree or os.DirFS.
+func LoadErrorPages(fsys fs.FS) (*ErrorPages, error) {
+    entries, err := fs.ReadDir(fsys, ".")
+    if err != nil {
+        return nil, err
+    }
+
+    partials := []string{}
+    names := []string{}
+    for _, entry := range entries {
+        name := entry.Name()
+        if entry.IsDir() || path.Ext(name) != ".html" {
+            continue
+        }
+        if strings.HasPrefix(name, "_") {
+            partials = append(partials, name)
+        } else {
+            names = append(names, name)
+        }
+    }
+
+    ep := &ErrorPages{pages: map[string]*template.Template{}}
+    for _, name := range names {
+        tmpl, err := template.New(name).ParseFS(fsys, append([]string{name}, partials...)...)
+        if err != nil {
+            return nil, fmt.Errorf("error while parsing error page %v: %w", name, err)
+        }
+        ep.pages[strings.TrimSuffix(name, ".html")] = tmpl
+    }
+
+    return ep, nil
+}
+
+// LoadErrorPagesDir loads the pages in a directory, see LoadErrorPages.
+func LoadErrorPagesDir(dir string) (*ErrorPages, error) {
+    return LoadErrorPages(os.DirFS(dir))
+}
+
+// Lookup returns the page for statusCode, or nil if none applies.
+func (ep *ErrorPages) Lookup(statusCode int) *template.Template {
+    if ep == nil {
+        return nil
+    }
+    code := strconv.Itoa(statusCode)
+    for _, name := range []string{code, code[:1] + "xx", "error"} {
+        if tmpl, ok := ep.pages[name]; ok {
+            return tmpl
+        }
+    }
+    return nil
+}
+
+// Render writes the page for p to dst, falling back to a plain built-in
+// page when no template applies.
+func (ep *ErrorPages) Render(dst io.Writer, req *request.Request, p *Problem) error {
+    data := ErrorPageData{Status: p.Status, Title: p.Title, Detail: p.Detail}
+    if req != nil {
+        data.Method = req.RequestLine.Method
+        data.Path = req.URL.Path
+        data.RequestID = req.ID()
+    }
+
+    tmpl := ep.Lookup(p.Status)
+    if tmpl == nil {
+        tmpl = problemTemplate
+    }
+    return tmpl.Execute(dst, data)
+}
+
+// SetErrorPages makes WriteProblem render HTML with pages. The server sets
+// the pages from its Config on every Writer it hands to a handler.
+func (w *Writer) SetErrorPages(pages *ErrorPages) {
+    w.errorPages = pages
+}
+
+// ErrorPages returns the pages set on w, which may be nil.
+func (w *Writer) ErrorPages() *ErrorPages {
+    return w.errorPages
+}
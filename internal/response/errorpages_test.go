@@ -0,0 +1,109 @@
+package response
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+    "testing/fstest"
+
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestErrorPages(t *testing.T) {
+    pages, err := LoadErrorPages(fstest.MapFS{
+        "404.html": {Data: []byte(`{{template "who" .}} lost {{.Path}}`)},
+        "4xx.html": {Data: []byte(`client {{.Status}} {{.Title}}`)},
+        "error.html": {Data: []byte(`fallback {{.Status}}: {{.Detail}}`)},
+        "_who.html": {Data: []byte(`{{define "who"}}{{.Method}}{{end}}`)},
+        "notes.txt": {Data: []byte(`not a page`)},
+        "partials/500.html": {Data: []byte(`nested pages are ignored`)},
+    })
+    require.NoError(t, err)
+    req := testRequest(t, "DELETE", nil)
+
+    // Test: Exact status, then its class, then error.html
+    tests := []struct {
+        status int
+        want   string
+    }{
+        {404, "DELETE lost /"},
+        {405, "client 405 Method Not Allowed"},
+        {500, "fallback 500: broken"},
+        {503, "fallback 503: broken"},
+    }
+    for _, tt := range tests {
+        body := &strings.Builder{}
+        require.NoError(t, pages.Render(body, req, NewProblem(tt.status, "broken")))
+        assert.Equal(t, tt.want, body.String())
+    }
+
+    // Test: Without a matching page the built-in one is used
+    pages, err = LoadErrorPages(fstest.MapFS{"404.html": {Data: []byte(`gone`)}})
+    require.NoError(t, err)
+    body := &strings.Builder{}
+    require.NoError(t, pages.Render(body, nil, NewProblem(500, "<script>")))
+    assert.Contains(t, body.String(), "<h1>Internal Server Error</h1>")
+    assert.Contains(t, body.String(), "<p>&lt;script&gt;</p>")
+
+    // Test: Nil pages always use the built-in one
+    var none *ErrorPages
+    assert.Nil(t, none.Lookup(404))
+    body.Reset()
+    require.NoError(t, none.Render(body, req, NewProblem(404, "")))
+    assert.Contains(t, body.String(), "<title>404 Not Found</title>")
+    assert.NotContains(t, body.String(), "<p>")
+
+    // Test: Templates that don't parse are reported
+    _, err = LoadErrorPages(fstest.MapFS{"error.html": {Data: []byte(`{{.Status`)}})
+    assert.Error(t, err)
+}
+
+func TestWriteProblem(t *testing.T) {
+    pages, err := LoadErrorPages(fstest.MapFS{"4xx.html": {Data: []byte(`custom {{.Status}}`)}})
+    require.NoError(t, err)
+
+    tests := []struct {
+        name        string
+        accept      string
+        pages       *ErrorPages
+        status      int
+        contentType string
+        body        string
+    }{
+        {"no Accept", "", pages, 404, "application/problem+json", `"status":404`},
+        {"JSON preferred", "application/json, text/html;q=0.9", pages, 404, "application/problem+json", `"title":"Not Found"`},
+        {"HTML preferred", "text/html,application/xhtml+xml,*/*;q=0.8", pages, 404, "text/html; charset=utf-8", "custom 404"},
+        {"HTML without a matching page", "text/html", pages, 503, "text/html; charset=utf-8", "<h1>Service Unavailable</h1>"},
+        {"HTML without pages", "text/html", nil, 404, "text/html; charset=utf-8", "<h1>Not Found</h1>"},
+    }
+
+    for _, tt := range tests {
+        fields := map[string]string{}
+        if tt.accept != "" {
+            fields["Accept"] = tt.accept
+        }
+        buf := &bytes.Buffer{}
+        w := NewWriter(buf)
+        w.SetErrorPages(tt.pages)
+        h := GetDefaultHeaders(0)
+        h.Set("Allow", "GET")
+        require.NoError(t, w.WriteProblem(testRequest(t, "GET", fields), NewProblem(tt.status, ""), h), tt.name)
+
+        resp, respBody := readResponse(t, buf)
+        assert.Equal(t, tt.status, resp.StatusCode, tt.name)
+        assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"), tt.name)
+        assert.Equal(t, "Accept", resp.Header.Get("Vary"), tt.name)
+        assert.Equal(t, "GET", resp.Header.Get("Allow"), tt.name)
+        assert.Contains(t, string(respBody), tt.body, tt.name)
+    }
+
+    // Test: Without a request the problem is JSON
+    buf := &bytes.Buffer{}
+    w := NewWriter(buf)
+    w.SetErrorPages(pages)
+    require.NoError(t, w.WriteProblem(nil, NewProblem(400, "bad request line"), nil))
+    resp, respBody := readResponse(t, buf)
+    assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+    assert.Contains(t, string(respBody), `"detail":"bad request line"`)
+}
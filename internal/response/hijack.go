@@ -0,0 +1,70 @@
+package response
+
+import (
+    "errors"
+    "net"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+)
+
+var ErrNotHijackable = errors.New("connection can't be hijacked")
+var ErrHijacked = errors.New("connection has been hijacked")
+
+// HijackFunc hands the connection over, along with any bytes the client
+// sent that were already read off it.
+type HijackFunc func() (net.Conn, []byte, error)
+
+// hijackedWriter rejects writes once the connection belongs to someone else
+type hijackedWriter struct{}
+
+func (hijackedWriter) Write(p []byte) (int, error) {
+    return 0, ErrHijacked
+}
+
+// NewHijackableWriter returns a Writer for conn whose Hijack calls hijack.
+func NewHijackableWriter(conn net.Conn, hijack HijackFunc) *Writer {
+    return &Writer{ Writer: conn, hijack: hijack }
+}
+
+// NewHijackableSinkWriter returns a Writer for sink whose Hijack calls
+// hijack.
+func NewHijackableSinkWriter(sink Sink, hijack HijackFunc) *Writer {
+    return &Writer{ sink: sink, hijack: hijack }
+}
+
+// Hijack takes over the connection. The server stops managing it, so the
+// caller must close it. The Writer can't be used afterwards and the
+// request context is canceled once the handler returns.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+    if w.hijack == nil {
+        return nil, nil, ErrNotHijackable
+    }
+    if w.hijacked {
+        return nil, nil, ErrHijacked
+    }
+
+    conn, buffered, err := w.hijack()
+    if err != nil {
+        return nil, nil, err
+    }
+    w.hijacked = true
+    w.Writer = hijackedWriter{}
+
+    return conn, buffered, nil
+}
+
+// WriteSwitchingProtocols answers an Upgrade request with 101 Switching
+// Protocols, after which the connection speaks protocol.
+func (w *Writer) WriteSwitchingProtocols(protocol string, h headers.Headers) error {
+    if h == nil {
+        h = headers.NewHeaders()
+    }
+    h.Replace("Connection", "Upgrade")
+    h.Replace("Upgrade", protocol)
+
+    err := w.WriteStatusLine(101)
+    if err != nil {
+        return err
+    }
+    return w.WriteHeaders(h)
+}
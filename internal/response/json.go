@@ -0,0 +1,39 @@
+package response
+
+import (
+    "encoding/json"
+    "errors"
+
+    "github.com/aringq10/http-go-server/internal/request"
+)
+
+// WriteJSON sends v as a JSON body. If v can't be encoded nothing has been
+// written yet and a 500 problem goes out instead.
+func (w *Writer) WriteJSON(statusCode int, v any) error {
+    body, err := json.Marshal(v)
+    if err != nil {
+        w.WriteJSONError(err)
+        return err
+    }
+
+    h := GetDefaultHeaders(0)
+    h.Replace("Content-Type", "application/json")
+    return w.WriteHttpMessage(statusCode, h, append(body, '\n'))
+}
+
+// WriteJSONError sends err as application/problem+json. A Problem is sent
+// as is, a request.BodyError keeps its status and message and anything
+// else becomes a 500 without details.
+func (w *Writer) WriteJSONError(err error) error {
+    var p *Problem
+    var bodyErr *request.BodyError
+    switch {
+    case errors.As(err, &p):
+    case errors.As(err, &bodyErr):
+        p = NewProblem(bodyErr.Status, bodyErr.Error())
+    default:
+        p = NewProblem(500, "")
+    }
+    // JSON clients get JSON whatever they accept
+    return w.WriteProblem(nil, p, nil)
+}
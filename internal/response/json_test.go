@@ -0,0 +1,58 @@
+package response
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "testing"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestWriteJSON(t *testing.T) {
+    // Test: Value encoded with a trailing newline
+    buf := &bytes.Buffer{}
+    require.NoError(t, NewWriter(buf).WriteJSON(200, map[string]any{"name": "lane", "tags": []string{"a"}}))
+    resp, respBody := readResponse(t, buf)
+    assert.Equal(t, 200, resp.StatusCode)
+    assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+    assert.Equal(t, "{\"name\":\"lane\",\"tags\":[\"a\"]}\n", string(respBody))
+
+    // Test: Unencodable values become a 500 problem
+    buf.Reset()
+    assert.Error(t, NewWriter(buf).WriteJSON(200, map[string]any{"ch": make(chan int)}))
+    resp, respBody = readResponse(t, buf)
+    assert.Equal(t, 500, resp.StatusCode)
+    assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+}
+
+func TestWriteJSONError(t *testing.T) {
+    tests := []struct {
+        name       string
+        err        error
+        wantStatus int
+        wantDetail any
+    }{
+        {"problem", &Problem{Status: 409, Title: "Conflict", Detail: "taken", Extensions: map[string]any{"field": "name"}}, 409, "taken"},
+        {"wrapped problem", errors.Join(errors.New("context"), NewProblem(422, "bad name")), 422, "bad name"},
+        {"body error", &request.BodyError{Status: 413, Err: errors.New("body too large")}, 413, "body too large"},
+        {"anything else", errors.New("database password is hunter2"), 500, nil},
+    }
+
+    for _, tt := range tests {
+        buf := &bytes.Buffer{}
+        require.NoError(t, NewWriter(buf).WriteJSONError(tt.err), tt.name)
+        resp, respBody := readResponse(t, buf)
+        assert.Equal(t, tt.wantStatus, resp.StatusCode, tt.name)
+        assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"), tt.name)
+
+        problem := map[string]any{}
+        require.NoError(t, json.Unmarshal(respBody, &problem), tt.name)
+        assert.Equal(t, "about:blank", problem["type"], tt.name)
+        assert.Equal(t, StatusText(tt.wantStatus), problem["title"], tt.name)
+        assert.Equal(t, float64(tt.wantStatus), problem["status"], tt.name)
+        assert.Equal(t, tt.wantDetail, problem["detail"], tt.name)
+    }
+}
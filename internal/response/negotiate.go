@@ -0,0 +1,14 @@
+package response
+
+import (
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+)
+
+// Negotiate picks one of offers by the request's field, see
+// headers.Negotiate, and lists field in h's Vary header since the choice
+// depends on it. When ok is false the response should be a 406.
+func Negotiate(req *request.Request, h headers.Headers, field string, offers []string) (best string, ok bool) {
+    h.AddVary(field)
+    return headers.Negotiate(req.Headers, field, offers)
+}
@@ -0,0 +1,42 @@
+package response
+
+import (
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+    offers := []string{"application/json", "text/html"}
+    tests := []struct {
+        name   string
+        accept map[string]string
+        want   string
+        wantOk bool
+    }{
+        {"no Accept", nil, "application/json", true},
+        {"exact", map[string]string{"Accept": "text/html"}, "text/html", true},
+        {"quality", map[string]string{"Accept": "application/json;q=0.5, text/html"}, "text/html", true},
+        {"wildcard tie", map[string]string{"Accept": "*/*"}, "application/json", true},
+        {"subtype wildcard", map[string]string{"Accept": "text/*"}, "text/html", true},
+        {"excluded", map[string]string{"Accept": "text/html;q=0, */*;q=0.1"}, "application/json", true},
+        {"nothing acceptable", map[string]string{"Accept": "image/png"}, "", false},
+    }
+
+    for _, tt := range tests {
+        h := GetDefaultHeaders(0)
+        best, ok := Negotiate(testRequest(t, "GET", tt.accept), h, "Accept", offers)
+        assert.Equal(t, tt.want, best, tt.name)
+        assert.Equal(t, tt.wantOk, ok, tt.name)
+        // Test: Vary lists the field whatever the outcome
+        assert.Equal(t, "Accept", h.Get("Vary"), tt.name)
+    }
+
+    // Test: Other fields and Vary merging
+    h := GetDefaultHeaders(0)
+    h.Set("Vary", "Accept-Encoding")
+    best, ok := Negotiate(testRequest(t, "GET", map[string]string{"Accept-Language": "de;q=0.5, en"}), h, "Accept-Language", []string{"de", "en-GB"})
+    assert.True(t, ok)
+    assert.Equal(t, "en-GB", best)
+    assert.Equal(t, "Accept-Encoding, Accept-Language", h.Get("Vary"))
+}
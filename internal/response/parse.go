@@ -0,0 +1,305 @@
+package response
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+)
+
+const (
+    responseStateInitialized int = iota
+    responseStateParsingStatusLine
+    responseStateParsingHeaders
+    responseStateParsingBody
+    responseStateParsingChunkSize
+    responseStateParsingChunkData
+    responseStateParsingChunkEnd
+    responseStateParsingTrailers
+    responseStateParsingUntilClose
+    responseStateDone
+)
+
+const crlf = "\r\n"
+const bufferSize = 4096
+
+// Response is an HTTP/1.x response read off the wire by FromReader. Body is
+// the payload with any chunked framing removed.
+type Response struct {
+    StatusLine StatusLine
+    Headers headers.Headers
+    Body []byte
+    // Trailers holds the fields sent after a chunked body
+    Trailers headers.Headers
+    state int
+    noBody bool
+    // remaining is what's left of a Content-Length body or the current
+    // chunk
+    remaining int
+    buffered []byte
+}
+
+type StatusLine struct {
+    HttpVersion  string
+    StatusCode   int
+    ReasonPhrase string
+}
+
+// FromReader reads one response from reader. req is the request it
+// answers, which matters for HEAD as those responses have no body. It may
+// be nil. Informational responses are returned like any other, the final
+// response follows in Buffered and the rest of reader.
+func FromReader(reader io.Reader, req *request.Request) (*Response, error) {
+    resp := &Response{
+        Headers: headers.NewHeaders(),
+        Trailers: headers.NewHeaders(),
+        state: responseStateInitialized,
+        noBody: req != nil && req.RequestLine.Method == "HEAD",
+    }
+    buf := make([]byte, bufferSize)
+    readToIndex := 0 // Index up to which the buffer is filled
+
+    for resp.state != responseStateDone {
+        if readToIndex >= len(buf) {
+            extBuf := make([]byte, len(buf) * 2)
+            copy(extBuf, buf)
+            buf = extBuf
+        }
+
+        n, readErr := reader.Read(buf[readToIndex:])
+        readToIndex += n
+
+        n, err := resp.parse(buf[:readToIndex])
+        if err != nil {
+            return nil, err
+        }
+        copy(buf, buf[n:readToIndex])
+        readToIndex -= n
+
+        if readErr == nil || resp.state == responseStateDone {
+            continue
+        }
+        if !errors.Is(readErr, io.EOF) {
+            return nil, readErr
+        }
+        switch resp.state {
+        case responseStateInitialized, responseStateParsingStatusLine:
+            return nil, errors.New("error: missing status line")
+        case responseStateParsingHeaders:
+            return nil, errors.New("error: missing end of headers")
+        case responseStateParsingBody:
+            return nil, errors.New("error: body smaller than reported content length")
+        case responseStateParsingUntilClose:
+            // The connection closing is what ends this body
+            resp.state = responseStateDone
+        default:
+            return nil, errors.New("error: chunked body ended early")
+        }
+    }
+
+    if readToIndex > 0 {
+        resp.buffered = append([]byte{}, buf[:readToIndex]...)
+    }
+
+    return resp, nil
+}
+
+// Buffered returns the bytes FromReader read past the end of the response,
+// e.g. the start of the next one.
+func (r *Response) Buffered() []byte {
+    return r.buffered
+}
+
+func (r *Response) parse(data []byte) (totalBytesParsed int, err error) {
+    for r.state != responseStateDone {
+        n, err := r.parseSingle(data[totalBytesParsed:])
+        if err != nil {
+            return 0, err
+        }
+        if n == 0 {
+            break
+        }
+        totalBytesParsed += n
+    }
+
+    return
+}
+
+func (r *Response) parseSingle(data []byte) (n int, err error) {
+    switch r.state {
+    case responseStateInitialized, responseStateParsingStatusLine:
+        r.state = responseStateParsingStatusLine
+        r.StatusLine, n, err = parseStatusLine(data)
+        if err != nil {
+            return 0, fmt.Errorf("error while parsing status line: %v", err.Error())
+        }
+        if n != 0 {
+            r.state = responseStateParsingHeaders
+        }
+        return
+    case responseStateParsingHeaders:
+        totalBytesParsed := 0
+        finished := false
+
+        for !finished {
+            n, finished, err = r.Headers.Parse(data[totalBytesParsed:])
+            if err != nil {
+                return 0, fmt.Errorf("error while parsing headers: %v", err.Error())
+            }
+            if n == 0 {
+                break
+            }
+
+            totalBytesParsed += n
+        }
+        if finished {
+            if err := r.startBody(); err != nil {
+                return 0, err
+            }
+        }
+
+        return totalBytesParsed, nil
+    case responseStateParsingBody:
+        n = min(r.remaining, len(data))
+        r.Body = append(r.Body, data[:n]...)
+        r.remaining -= n
+        if r.remaining == 0 {
+            r.state = responseStateDone
+        }
+        return n, nil
+    case responseStateParsingChunkSize:
+        line, rest, found := strings.Cut(string(data), crlf)
+        if !found {
+            return 0, nil
+        }
+        // Chunk extensions are ignored
+        sizeStr, _, _ := strings.Cut(line, ";")
+        size, errConv := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+        if errConv != nil || size < 0 {
+            return 0, fmt.Errorf("error: invalid chunk size %q", line)
+        }
+        r.remaining = int(size)
+        r.state = responseStateParsingChunkData
+        if size == 0 {
+            r.state = responseStateParsingTrailers
+        }
+        return len(data) - len(rest), nil
+    case responseStateParsingChunkData:
+        n = min(r.remaining, len(data))
+        r.Body = append(r.Body, data[:n]...)
+        r.remaining -= n
+        if r.remaining == 0 {
+            r.state = responseStateParsingChunkEnd
+        }
+        return n, nil
+    case responseStateParsingChunkEnd:
+        if len(data) < len(crlf) {
+            return 0, nil
+        }
+        if string(data[:len(crlf)]) != crlf {
+            return 0, errors.New("error: chunk data not followed by CRLF")
+        }
+        r.state = responseStateParsingChunkSize
+        return len(crlf), nil
+    case responseStateParsingTrailers:
+        totalBytesParsed := 0
+        finished := false
+
+        for r.state != responseStateDone {
+            n, finished, err = r.Trailers.Parse(data[totalBytesParsed:])
+            if err != nil {
+                return 0, fmt.Errorf("error while parsing trailers: %v", err.Error())
+            }
+            if n == 0 {
+                break
+            }
+            if finished {
+                r.state = responseStateDone
+            }
+
+            totalBytesParsed += n
+        }
+
+        return totalBytesParsed, nil
+    case responseStateParsingUntilClose:
+        r.Body = append(r.Body, data...)
+        return len(data), nil
+    case responseStateDone:
+        return 0, errors.New("error: trying to read data in a responseStateDone state")
+    default:
+        return 0, errors.New("error: unknown parser state")
+    }
+}
+
+// startBody picks how the body is delimited, as RFC 9112 section 6.3 lays
+// out
+func (r *Response) startBody() error {
+    code := r.StatusLine.StatusCode
+    if r.noBody || code < 200 || code == 204 || code == 304 {
+        r.state = responseStateDone
+        return nil
+    }
+
+    if te := r.Headers.Get("Transfer-Encoding"); te != "" {
+        codings := strings.Split(te, ",")
+        if strings.EqualFold(strings.TrimSpace(codings[len(codings) - 1]), "chunked") {
+            r.state = responseStateParsingChunkSize
+        } else {
+            r.state = responseStateParsingUntilClose
+        }
+        return nil
+    }
+
+    lengthStr := r.Headers.Get("Content-Length")
+    if lengthStr == "" {
+        r.state = responseStateParsingUntilClose
+        return nil
+    }
+    // Repeated Content-Length fields are fine as long as they agree
+    length := -1
+    for _, value := range strings.Split(lengthStr, ",") {
+        l, err := strconv.Atoi(strings.TrimSpace(value))
+        if err != nil || l < 0 || (length >= 0 && l != length) {
+            return fmt.Errorf("error: invalid content length %q", lengthStr)
+        }
+        length = l
+    }
+
+    r.state = responseStateParsingBody
+    r.remaining = length
+    if length == 0 {
+        r.state = responseStateDone
+    }
+    return nil
+}
+
+func parseStatusLine(data []byte) (statusLine StatusLine, n int, err error) {
+    line, _, found := strings.Cut(string(data), crlf)
+    if !found {
+        return StatusLine{}, 0, nil
+    }
+    n = len(line) + len(crlf)
+
+    version, rest, _ := strings.Cut(line, " ")
+    codeStr, reasonPhrase, _ := strings.Cut(rest, " ")
+
+    switch version {
+    case "HTTP/1.1", "HTTP/1.0":
+        statusLine.HttpVersion = strings.TrimPrefix(version, "HTTP/")
+    default:
+        return StatusLine{}, 0, errors.New("invalid HTTP version")
+    }
+
+    code, errConv := strconv.Atoi(codeStr)
+    if errConv != nil || len(codeStr) != 3 || code < 100 || code > 599 {
+        return StatusLine{}, 0, errors.New("invalid status code")
+    }
+    statusLine.StatusCode = code
+    statusLine.ReasonPhrase = reasonPhrase
+
+    return
+}
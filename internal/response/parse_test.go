@@ -0,0 +1,150 @@
+package response
+
+import (
+    "bytes"
+    "io"
+    "strings"
+    "testing"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// chunkReader hands out at most numBytesPerRead bytes per Read, like a
+// network connection delivering data in pieces
+type chunkReader struct {
+    data            string
+    numBytesPerRead int
+    pos             int
+}
+
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+    if cr.pos >= len(cr.data) {
+        return 0, io.EOF
+    }
+    endIndex := min(cr.pos + cr.numBytesPerRead, len(cr.data))
+    n = copy(p, cr.data[cr.pos:endIndex])
+    cr.pos += n
+
+    return n, nil
+}
+
+func TestFromReader(t *testing.T) {
+    // Test: Content-Length body, split at every size
+    raw := "HTTP/1.1 200 OK\r\nContent-Length: 13\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\n\r\nhello world!\n"
+    for i := 1; i <= len(raw); i++ {
+        resp, err := FromReader(&chunkReader{data: raw, numBytesPerRead: i}, nil)
+        require.NoError(t, err)
+        assert.Equal(t, StatusLine{"1.1", 200, "OK"}, resp.StatusLine)
+        assert.Equal(t, []string{"a=1", "b=2"}, resp.Headers.Values("Set-Cookie"))
+        assert.Equal(t, "hello world!\n", string(resp.Body))
+    }
+
+    // Test: Chunked body with extensions and trailers
+    raw = "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+        "5;ext=1\r\nhello\r\n7\r\n world!\r\n0\r\nX-Sum: 42\r\n\r\n"
+    for i := 1; i <= len(raw); i++ {
+        resp, err := FromReader(&chunkReader{data: raw, numBytesPerRead: i}, nil)
+        require.NoError(t, err)
+        assert.Equal(t, "hello world!", string(resp.Body))
+        assert.Equal(t, "42", resp.Trailers.Get("X-Sum"))
+    }
+
+    // Test: Body delimited by the connection closing
+    resp, err := FromReader(strings.NewReader("HTTP/1.0 200 OK\r\n\r\nuntil close"), nil)
+    require.NoError(t, err)
+    assert.Equal(t, "1.0", resp.StatusLine.HttpVersion)
+    assert.Equal(t, "until close", string(resp.Body))
+
+    // Test: No body for HEAD, 204 and 304, the rest stays buffered
+    head, err := request.RequestFromReader(strings.NewReader("HEAD / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+    require.NoError(t, err)
+    resp, err = FromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"), head)
+    require.NoError(t, err)
+    assert.Empty(t, resp.Body)
+    resp, err = FromReader(strings.NewReader("HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\nHTTP/1.1 200 OK\r\n"), nil)
+    require.NoError(t, err)
+    assert.Empty(t, resp.Body)
+    assert.Equal(t, "HTTP/1.1 200 OK\r\n", string(resp.Buffered()))
+
+    // Test: Informational responses leave the final one buffered
+    resp, err = FromReader(strings.NewReader("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n"), nil)
+    require.NoError(t, err)
+    assert.Equal(t, 100, resp.StatusLine.StatusCode)
+    resp, err = FromReader(bytes.NewReader(resp.Buffered()), nil)
+    require.NoError(t, err)
+    assert.Equal(t, 204, resp.StatusLine.StatusCode)
+
+    // Test: Empty reason phrase and agreeing repeated lengths
+    resp, err = FromReader(strings.NewReader("HTTP/1.1 404 \r\nContent-Length: 2\r\nContent-Length: 2\r\n\r\nno"), nil)
+    require.NoError(t, err)
+    assert.Equal(t, "", resp.StatusLine.ReasonPhrase)
+    assert.Equal(t, "no", string(resp.Body))
+
+    // Test: Malformed responses
+    for _, raw := range []string{
+        "",
+        "HTTP/2 200 OK\r\n\r\n",
+        "HTTP/1.1 20 OK\r\n\r\n",
+        "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+        "HTTP/1.1 200 OK\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nab",
+        "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+        "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello",
+        "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhelloXX0\r\n\r\n",
+        "HTTP/1.1 200 OK\r\nBroken header\r\n\r\n",
+    } {
+        _, err := FromReader(strings.NewReader(raw), nil)
+        assert.Error(t, err, raw)
+    }
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+    // Test: Full message
+    buf := &bytes.Buffer{}
+    h := GetDefaultHeaders(0)
+    h.Add("Set-Cookie", "a=1")
+    h.Add("Set-Cookie", "b=2")
+    require.NoError(t, NewWriter(buf).WriteHttpMessage(201, h, []byte("created")))
+    resp, err := FromReader(buf, nil)
+    require.NoError(t, err)
+    assert.Equal(t, StatusLine{"1.1", 201, "Created"}, resp.StatusLine)
+    assert.Equal(t, []string{"a=1", "b=2"}, resp.Headers.Values("Set-Cookie"))
+    assert.Equal(t, "created", string(resp.Body))
+
+    // Test: Chunked body and trailers
+    buf.Reset()
+    NewWriter(buf).WriteChunksFromReader(strings.NewReader(strings.Repeat("video", 20)), GetDefaultHeaders(0))
+    resp, err = FromReader(buf, nil)
+    require.NoError(t, err)
+    assert.Equal(t, strings.Repeat("video", 20), string(resp.Body))
+    assert.Equal(t, "100", resp.Trailers.Get("X-Content-Length"))
+    assert.Empty(t, resp.Buffered())
+
+    // Test: Encoded body through a filter
+    buf.Reset()
+    w := NewWriter(buf)
+    w.AddFilter(func(statusCode int, h headers.Headers) BodyEncoder {
+        return func(dst io.Writer) io.WriteCloser {
+            return upperEncoder{dst}
+        }
+    })
+    require.NoError(t, w.WriteHttpMessage(200, GetDefaultHeaders(0), []byte("shout")))
+    resp, err = FromReader(buf, nil)
+    require.NoError(t, err)
+    assert.Equal(t, "chunked", resp.Headers.Get("Transfer-Encoding"))
+    assert.Equal(t, "SHOUT", string(resp.Body))
+}
+
+type upperEncoder struct {
+    dst io.Writer
+}
+
+func (ue upperEncoder) Write(p []byte) (int, error) {
+    return ue.dst.Write(bytes.ToUpper(p))
+}
+
+func (ue upperEncoder) Close() error {
+    return nil
+}
@@ -0,0 +1,120 @@
+package response
+
+import (
+    "encoding/json"
+    "html/template"
+    "strings"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+)
+
+// Problem is an RFC 9457 problem details object.
+type Problem struct {
+    // Type is a URI naming the kind of problem, "about:blank" if empty
+    Type     string
+    Title    string
+    Status   int
+    Detail   string
+    Instance string
+    // Extensions are extra members, names of the standard ones are ignored
+    Extensions map[string]any
+}
+
+var problemTemplate = template.Must(template.New("problem").Parse(`<html>
+  <head>
+    <title>{{.Status}} {{.Title}}</title>
+  </head>
+  <body>
+    <h1>{{.Title}}</h1>
+{{- if .Detail}}
+    <p>{{.Detail}}</p>
+{{- end}}
+  </body>
+</html>
+`))
+
+// NewProblem returns a problem for statusCode titled with its reason phrase.
+func NewProblem(statusCode int, detail string) *Problem {
+    return &Problem{Status: statusCode, Title: StatusText(statusCode), Detail: detail}
+}
+
+func (p *Problem) Error() string {
+    if p.Detail != "" {
+        return p.Title + ": " + p.Detail
+    }
+    return p.Title
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+    members := map[string]any{}
+    for name, value := range p.Extensions {
+        members[name] = value
+    }
+
+    members["type"] = p.Type
+    if p.Type == "" {
+        members["type"] = "about:blank"
+    }
+    members["title"] = p.Title
+    members["status"] = p.Status
+    if p.Detail != "" {
+        members["detail"] = p.Detail
+    } else {
+        delete(members, "detail")
+    }
+    if p.Instance != "" {
+        members["instance"] = p.Instance
+    } else {
+        delete(members, "instance")
+    }
+
+    return json.Marshal(members)
+}
+
+// WriteProblem sends p as application/problem+json, or as an HTML page when
+// the request's Accept header prefers text/html, see SetErrorPages. req
+// may be nil when no request could be parsed. h may be nil or hold extra
+// headers like Allow.
+func (w *Writer) WriteProblem(req *request.Request, p *Problem, h headers.Headers) error {
+    if h == nil {
+        h = GetDefaultHeaders(0)
+    }
+    if p.Title == "" {
+        p.Title = StatusText(p.Status)
+    }
+    h.AddVary("Accept")
+
+    accept := ""
+    if req != nil {
+        accept = req.Headers.Get("Accept")
+    }
+
+    if prefersHTML(accept) {
+        body := &strings.Builder{}
+        if err := w.errorPages.Render(body, req, p); err != nil {
+            return err
+        }
+        h.Replace("Content-Type", "text/html; charset=utf-8")
+        return w.WriteHttpMessage(p.Status, h, []byte(body.String()))
+    }
+
+    body, err := json.Marshal(p)
+    if err != nil {
+        return err
+    }
+    h.Replace("Content-Type", "application/problem+json")
+    return w.WriteHttpMessage(p.Status, h, append(body, '\n'))
+}
+
+// prefersHTML reports whether accept rates text/html above every JSON
+// type a problem can be sent as. Clients that say nothing get JSON.
+func prefersHTML(accept string) bool {
+    if accept == "" {
+        return false
+    }
+    items := headers.ParseAccept(accept)
+    htmlQ := headers.MediaTypeQuality(items, "text/html")
+    jsonQ := max(headers.MediaTypeQuality(items, "application/problem+json"), headers.MediaTypeQuality(items, "application/json"))
+    return htmlQ > 0 && htmlQ > jsonQ
+}
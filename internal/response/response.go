@@ -12,24 +12,99 @@ import (
 
 
 var reasonPhrases = map[int]string{
+    101: "Switching Protocols",
     200: "OK",
+    201: "Created",
+    202: "Accepted",
+    204: "No Content",
+    206: "Partial Content",
+    301: "Moved Permanently",
+    302: "Found",
+    303: "See Other",
+    304: "Not Modified",
+    307: "Temporary Redirect",
+    308: "Permanent Redirect",
     400: "Bad Request",
+    401: "Unauthorized",
+    403: "Forbidden",
     404: "Not Found",
+    405: "Method Not Allowed",
+    406: "Not Acceptable",
+    409: "Conflict",
+    412: "Precondition Failed",
+    413: "Content Too Large",
+    415: "Unsupported Media Type",
+    416: "Range Not Satisfiable",
+    422: "Unprocessable Content",
+    429: "Too Many Requests",
+    431: "Request Header Fields Too Large",
     500: "Internal Server Error",
+    503: "Service Unavailable",
 }
 
+// StatusText returns the reason phrase for a status code, or "" if the
+// code is unknown.
+func StatusText(statusCode int) string {
+    return reasonPhrases[statusCode]
+}
+
+// Sink receives a response as status, headers, body and trailers instead of
+// HTTP/1.1 wire bytes, so protocols like HTTP/2 can reuse the Writer API.
+type Sink interface {
+    WriteHeader(statusCode int, h headers.Headers) error
+    Write(p []byte) (int, error)
+    // Close ends the response, trailers may be empty
+    Close(trailers headers.Headers) error
+}
+
+// BodyEncoder wraps the body writer, e.g. with a compressor. Closing the
+// returned writer must flush everything into dst.
+type BodyEncoder func(dst io.Writer) io.WriteCloser
+
+// Filter sees the status and headers right before they are written. It may
+// change the headers and return a BodyEncoder to transform the body, or nil
+// to leave it alone. An encoded body is always sent chunked.
+type Filter func(statusCode int, h headers.Headers) BodyEncoder
+
 type Writer struct {
     Writer io.Writer
+    sink Sink
+    statusCode int
+    headersWritten bool
+    hijack HijackFunc
+    hijacked bool
+    filters []Filter
+    // encoders are ordered from the one the body goes through first
+    encoders []io.WriteCloser
+    bodyDone bool
+    errorPages *ErrorPages
 }
 
 func NewWriter(writer io.Writer) *Writer {
     return &Writer{ Writer: writer }
 }
 
+func NewSinkWriter(sink Sink) *Writer {
+    return &Writer{ sink: sink }
+}
+
+// Written reports whether a response has been started or the connection
+// hijacked, after which nothing else can be sent.
+func (w *Writer) Written() bool {
+    return w.statusCode != 0 || w.hijacked
+}
+
 func (w *Writer) WriteStatusLine(statusCode int) error {
-    reasonPhrase, ok := reasonPhrases[statusCode]
-    if !ok {
-        return fmt.Errorf("unrecognized status code %v", statusCode)
+    if statusCode < 100 || statusCode > 599 {
+        return fmt.Errorf("invalid status code %v", statusCode)
+    }
+    // Codes missing from the table go out with an empty reason phrase,
+    // which clients ignore anyway
+    reasonPhrase := reasonPhrases[statusCode]
+
+    w.statusCode = statusCode
+    if w.sink != nil {
+        return nil
     }
 
     statusLine := fmt.Sprintf("HTTP/1.1 %v %v\r\n", statusCode, reasonPhrase)
@@ -40,10 +115,26 @@ func (w *Writer) WriteStatusLine(statusCode int) error {
 }
 
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
+    // Headers written after the first set are trailers
+    trailers := w.headersWritten
+    if !trailers {
+        w.headersWritten = true
+        w.applyFilters(headers)
+    }
+
+    if w.sink != nil {
+        if trailers {
+            return w.sink.Close(headers)
+        }
+        return w.sink.WriteHeader(w.statusCode, headers)
+    }
+
     b := []byte{}
 
-    for key, value := range headers {
-        b = fmt.Appendf(b, "%v: %v\r\n", key, value)
+    for key := range headers {
+        for _, value := range headers.Values(key) {
+            b = fmt.Appendf(b, "%v: %v\r\n", key, value)
+        }
     }
     b = fmt.Append(b, "\r\n")
 
@@ -53,9 +144,20 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 }
 
 func (w *Writer) WriteBody(body []byte) (int, error) {
+    if len(w.encoders) > 0 {
+        return w.encoders[0].Write(body)
+    }
+    if w.sink != nil {
+        return w.sink.Write(body)
+    }
     return w.Writer.Write(body)
 }
 
+// Write makes Writer an io.Writer for the response body.
+func (w *Writer) Write(p []byte) (int, error) {
+    return w.WriteBody(p)
+}
+
 func (w *Writer) WriteHttpMessage(statusCode int, h headers.Headers, body []byte) error {
     err := w.WriteStatusLine(statusCode)
     if err != nil {
@@ -67,10 +169,105 @@ func (w *Writer) WriteHttpMessage(statusCode int, h headers.Headers, body []byte
         return err
     }
     _, err = w.WriteBody(body)
+    if err != nil {
+        return err
+    }
+
+    if len(w.encoders) > 0 {
+        return w.Finish()
+    }
+    if w.sink != nil {
+        return w.sink.Close(headers.NewHeaders())
+    }
+
+    return nil
+}
+
+// AddFilter registers a filter for the headers that haven't been written
+// yet. Filters run in the order they were added, so the body goes through
+// the first filter's encoder first.
+func (w *Writer) AddFilter(f Filter) {
+    w.filters = append(w.filters, f)
+}
+
+// Finish ends a body that a filter switched to chunked encoding, flushing
+// the encoders. It does nothing for unfiltered responses.
+func (w *Writer) Finish() error {
+    if len(w.encoders) == 0 || w.bodyDone {
+        return nil
+    }
+
+    err := w.closeEncoders()
+    if err != nil {
+        return err
+    }
+    if w.sink != nil {
+        return w.sink.Close(headers.NewHeaders())
+    }
+    _, err = w.Writer.Write([]byte("0\r\n\r\n"))
 
     return err
 }
 
+func (w *Writer) applyFilters(h headers.Headers) {
+    if w.statusCode < 200 || w.hijacked {
+        return
+    }
+
+    bodyEncoders := []BodyEncoder{}
+    for _, f := range w.filters {
+        if enc := f(w.statusCode, h); enc != nil {
+            bodyEncoders = append(bodyEncoders, enc)
+        }
+    }
+    if len(bodyEncoders) == 0 {
+        return
+    }
+
+    h.Remove("Content-Length")
+    h.Replace("Transfer-Encoding", "chunked")
+
+    var dst io.Writer = chunkFramer{w}
+    if w.sink != nil {
+        dst = w.sink
+    }
+    w.encoders = make([]io.WriteCloser, len(bodyEncoders))
+    for i := len(bodyEncoders) - 1; i >= 0; i-- {
+        w.encoders[i] = bodyEncoders[i](dst)
+        dst = w.encoders[i]
+    }
+}
+
+func (w *Writer) closeEncoders() error {
+    w.bodyDone = true
+    for _, enc := range w.encoders {
+        if err := enc.Close(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// chunkFramer frames encoded output as HTTP/1.1 chunks
+type chunkFramer struct {
+    w *Writer
+}
+
+func (cf chunkFramer) Write(p []byte) (int, error) {
+    if len(p) == 0 {
+        return 0, nil
+    }
+    chunk := fmt.Appendf(nil, "%X\r\n", len(p))
+    chunk = append(chunk, p...)
+    chunk = fmt.Append(chunk, "\r\n")
+
+    _, err := cf.w.Writer.Write(chunk)
+    if err != nil {
+        return 0, err
+    }
+    return len(p), nil
+}
+
 func GetDefaultHeaders(contentLen int) headers.Headers {
     h := headers.NewHeaders()
     h.Set("Content-Length", strconv.Itoa(contentLen))
@@ -81,6 +278,15 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+    if len(w.encoders) > 0 {
+        // The encoded output gets framed instead
+        return w.encoders[0].Write(p)
+    }
+    if w.sink != nil {
+        // The sink does its own framing
+        return w.sink.Write(p)
+    }
+
     numLine := fmt.Sprintf("%X\r\n", len(p))
     p = fmt.Append(p, "\r\n")
     chunk := append([]byte(numLine), p...)
@@ -89,7 +295,19 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-    return w.WriteBody([]byte("0\r\n"))
+    if len(w.encoders) > 0 {
+        if w.bodyDone {
+            return 0, nil
+        }
+        if err := w.closeEncoders(); err != nil {
+            return 0, err
+        }
+    }
+    if w.sink != nil {
+        // The body ends with the trailers that follow
+        return 0, nil
+    }
+    return w.Writer.Write([]byte("0\r\n"))
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
@@ -103,7 +321,7 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
         if value == "" {
             continue
         }
-        trailerHeaders[trailer] = value
+        trailerHeaders.Replace(trailer, value)
     }
 
     return w.WriteHeaders(trailerHeaders)
@@ -128,7 +346,11 @@ func (w *Writer) WriteChunksFromReader(reader io.Reader, h headers.Headers) {
         bytesRead += n
         wholeResp = append(wholeResp, buf[:n]...)
 
-        w.WriteChunkedBody(buf[:n])
+        _, err = w.WriteChunkedBody(buf[:n])
+        if err != nil {
+            // The client is gone, there's nobody left to send trailers to
+            return
+        }
     }
 
     w.WriteChunkedBodyDone()
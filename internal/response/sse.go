@@ -0,0 +1,188 @@
+package response
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+)
+
+const DefaultSSEHeartbeat = 15 * time.Second
+
+var ErrSSEClosed = errors.New("sse stream closed")
+
+// SSEEvent is a single Server-Sent Event. Empty fields are omitted,
+// Data may span multiple lines.
+type SSEEvent struct {
+    ID    string
+    Event string
+    Data  string
+    Retry time.Duration
+}
+
+// SSEWriter streams text/event-stream messages over a chunked response.
+// It is safe for concurrent use.
+type SSEWriter struct {
+    w           *Writer
+    mu          sync.Mutex
+    lastEventID string
+    done        chan struct{}
+    closeOnce   sync.Once
+    closed      bool
+}
+
+// NewSSEWriter writes the status line and headers for an event stream and
+// starts sending comment heartbeats every heartbeat interval (0 disables them).
+// The stream is closed when the request context is done.
+func NewSSEWriter(w *Writer, req *request.Request, h headers.Headers, heartbeat time.Duration) (*SSEWriter, error) {
+    h.Remove("Content-Length")
+    h.Replace("Transfer-Encoding", "chunked")
+    h.Replace("Content-Type", "text/event-stream")
+    h.Replace("Cache-Control", "no-cache")
+
+    if err := w.WriteStatusLine(200); err != nil {
+        return nil, err
+    }
+    if err := w.WriteHeaders(h); err != nil {
+        return nil, err
+    }
+
+    s := &SSEWriter{
+        w: w,
+        lastEventID: req.Headers.Get("Last-Event-ID"),
+        done: make(chan struct{}),
+    }
+
+    go s.run(req.Context(), heartbeat)
+
+    return s, nil
+}
+
+// LastEventID returns the Last-Event-ID sent by a reconnecting client.
+func (s *SSEWriter) LastEventID() string {
+    return s.lastEventID
+}
+
+// Done is closed once the stream is closed or the client has gone away.
+func (s *SSEWriter) Done() <-chan struct{} {
+    return s.done
+}
+
+func (s *SSEWriter) Send(ev SSEEvent) error {
+    if strings.ContainsAny(ev.ID, "\r\n") {
+        return fmt.Errorf("sse event id contains a line break - \"%v\"", ev.ID)
+    }
+    if strings.ContainsAny(ev.Event, "\r\n") {
+        return fmt.Errorf("sse event name contains a line break - \"%v\"", ev.Event)
+    }
+
+    b := []byte{}
+    if ev.ID != "" {
+        b = fmt.Appendf(b, "id: %v\n", ev.ID)
+    }
+    if ev.Event != "" {
+        b = fmt.Appendf(b, "event: %v\n", ev.Event)
+    }
+    if ev.Retry > 0 {
+        b = fmt.Appendf(b, "retry: %d\n", ev.Retry.Milliseconds())
+    }
+    for _, line := range splitLines(ev.Data) {
+        b = fmt.Appendf(b, "data: %v\n", line)
+    }
+    b = fmt.Append(b, "\n")
+
+    return s.write(b)
+}
+
+// SendData sends an unnamed event carrying only data.
+func (s *SSEWriter) SendData(data string) error {
+    return s.Send(SSEEvent{Data: data})
+}
+
+// Comment sends a comment line, which clients ignore.
+func (s *SSEWriter) Comment(text string) error {
+    b := []byte{}
+    for _, line := range splitLines(text) {
+        b = fmt.Appendf(b, ": %v\n", line)
+    }
+    b = fmt.Append(b, "\n")
+
+    return s.write(b)
+}
+
+// Close stops the heartbeats and terminates the chunked body.
+func (s *SSEWriter) Close() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.closed {
+        return nil
+    }
+    s.closed = true
+    s.stop()
+
+    _, err := s.w.WriteChunkedBodyDone()
+    if err != nil {
+        return err
+    }
+    return s.w.WriteHeaders(headers.NewHeaders())
+}
+
+func (s *SSEWriter) write(b []byte) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.closed {
+        return ErrSSEClosed
+    }
+
+    _, err := s.w.WriteChunkedBody(b)
+    if err != nil {
+        // A failed write means the client is gone
+        s.closed = true
+        s.stop()
+    }
+
+    return err
+}
+
+func (s *SSEWriter) stop() {
+    s.closeOnce.Do(func() { close(s.done) })
+}
+
+func (s *SSEWriter) run(ctx context.Context, heartbeat time.Duration) {
+    var tick <-chan time.Time
+    if heartbeat > 0 {
+        ticker := time.NewTicker(heartbeat)
+        defer ticker.Stop()
+        tick = ticker.C
+    }
+
+    for {
+        select {
+        case <-s.done:
+            return
+        case <-ctx.Done():
+            s.mu.Lock()
+            s.closed = true
+            s.stop()
+            s.mu.Unlock()
+            return
+        case <-tick:
+            if s.Comment("heartbeat") != nil {
+                return
+            }
+        }
+    }
+}
+
+func splitLines(s string) []string {
+    s = strings.ReplaceAll(s, "\r\n", "\n")
+    s = strings.ReplaceAll(s, "\r", "\n")
+    return strings.Split(s, "\n")
+}
@@ -0,0 +1,139 @@
+package response
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// readResponse parses what a Writer wrote with net/http's parser
+func readResponse(t *testing.T, r io.Reader) (*http.Response, []byte) {
+    resp, err := http.ReadResponse(bufio.NewReader(r), nil)
+    require.NoError(t, err)
+    body, err := io.ReadAll(resp.Body)
+    require.NoError(t, err)
+    return resp, body
+}
+
+func sseRequest(t *testing.T, ctx context.Context) *request.Request {
+    req, err := request.RequestFromReader(strings.NewReader("GET /events HTTP/1.1\r\nHost: localhost:42069\r\nLast-Event-ID: 7\r\n\r\n"))
+    require.NoError(t, err)
+    return req.WithContext(ctx)
+}
+
+func TestSSEWriter(t *testing.T) {
+    serverConn, clientConn := net.Pipe()
+    defer clientConn.Close()
+    clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+    sent := make(chan error, 1)
+    go func() {
+        defer serverConn.Close()
+        sse, err := NewSSEWriter(NewWriter(serverConn), sseRequest(t, context.Background()), GetDefaultHeaders(0), 0)
+        if err != nil {
+            sent <- err
+            return
+        }
+        // Test: Last-Event-ID of a reconnecting client
+        if sse.LastEventID() != "7" {
+            sent <- fmt.Errorf("last event id %q", sse.LastEventID())
+            return
+        }
+        sse.SendData("first\nsecond\r\nthird")
+        sse.Send(SSEEvent{ID: "8", Event: "tick", Data: "one", Retry: 3 * time.Second})
+        sse.Comment("note")
+        sent <- sse.Close()
+    }()
+
+    resp, respBody := readResponse(t, clientConn)
+    require.NoError(t, <-sent)
+
+    // Test: Stream headers
+    assert.Equal(t, 200, resp.StatusCode)
+    assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+    assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+    assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+    assert.Empty(t, resp.Header.Get("Content-Length"))
+
+    // Test: Multi-line data, id, event and retry, comments
+    assert.Equal(t,
+        "data: first\ndata: second\ndata: third\n\n" +
+        "id: 8\nevent: tick\nretry: 3000\ndata: one\n\n" +
+        ": note\n\n",
+        string(respBody))
+
+    // Test: Line breaks in id or event are rejected
+    sse := &SSEWriter{done: make(chan struct{})}
+    assert.Error(t, sse.Send(SSEEvent{ID: "1\n2"}))
+    assert.Error(t, sse.Send(SSEEvent{Event: "a\rb"}))
+}
+
+func TestSSEWriterHeartbeat(t *testing.T) {
+    serverConn, clientConn := net.Pipe()
+    defer serverConn.Close()
+    clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    created := make(chan *SSEWriter, 1)
+    go func() {
+        sse, err := NewSSEWriter(NewWriter(serverConn), sseRequest(t, ctx), GetDefaultHeaders(0), 10 * time.Millisecond)
+        if err != nil {
+            close(created)
+            return
+        }
+        created <- sse
+    }()
+
+    // Test: Heartbeat comments arrive while nothing else is sent
+    reader := bufio.NewReader(clientConn)
+    seen := 0
+    for seen < 2 {
+        line, err := reader.ReadString('\n')
+        require.NoError(t, err)
+        if line == ": heartbeat\n" {
+            seen++
+        }
+    }
+    sse := <-created
+    require.NotNil(t, sse)
+
+    // Test: Done closes once the client disconnects and writes fail
+    clientConn.Close()
+    select {
+    case <-sse.Done():
+    case <-time.After(5 * time.Second):
+        t.Fatal("Done not closed after the client disconnected")
+    }
+    assert.ErrorIs(t, sse.SendData("late"), ErrSSEClosed)
+}
+
+func TestSSEWriterContext(t *testing.T) {
+    serverConn, clientConn := net.Pipe()
+    defer serverConn.Close()
+    defer clientConn.Close()
+    go io.Copy(io.Discard, clientConn)
+
+    // Test: Done closes when the request context is canceled
+    ctx, cancel := context.WithCancel(context.Background())
+    sse, err := NewSSEWriter(NewWriter(serverConn), sseRequest(t, ctx), GetDefaultHeaders(0), 0)
+    require.NoError(t, err)
+    cancel()
+    select {
+    case <-sse.Done():
+    case <-time.After(5 * time.Second):
+        t.Fatal("Done not closed after the context was canceled")
+    }
+    assert.ErrorIs(t, sse.SendData("late"), ErrSSEClosed)
+    assert.NoError(t, sse.Close())
+}
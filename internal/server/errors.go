@@ -0,0 +1,43 @@
+package server
+
+import (
+    "strings"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+)
+
+// NotFound answers every request with a 404 problem, as HTML for browsers.
+func NotFound(w *response.Writer, req *request.Request) {
+    w.WriteProblem(req, response.NewProblem(404, ""), nil)
+}
+
+// AllowMethods answers requests using any other method with a 405 problem
+// listing methods in its Allow header. HEAD is allowed along with GET.
+func AllowMethods(methods ...string) Middleware {
+    allowed := map[string]bool{}
+    list := []string{}
+    for _, method := range methods {
+        if !allowed[method] {
+            allowed[method] = true
+            list = append(list, method)
+        }
+    }
+    if allowed["GET"] && !allowed["HEAD"] {
+        allowed["HEAD"] = true
+        list = append(list, "HEAD")
+    }
+    allow := strings.Join(list, ", ")
+
+    return func(next Handler) Handler {
+        return func(w *response.Writer, req *request.Request) {
+            if !allowed[req.RequestLine.Method] {
+                h := response.GetDefaultHeaders(0)
+                h.Replace("Allow", allow)
+                w.WriteProblem(req, response.NewProblem(405, ""), h)
+                return
+            }
+            next(w, req)
+        }
+    }
+}
@@ -0,0 +1,12 @@
+package server
+
+// Middleware wraps a Handler with behaviour that runs around it.
+type Middleware func(next Handler) Handler
+
+// Chain wraps handler with middlewares, the first one being the outermost.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+    for i := len(middlewares) - 1; i >= 0; i-- {
+        handler = middlewares[i](handler)
+    }
+    return handler
+}
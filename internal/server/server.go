@@ -1,9 +1,18 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/aringq10/http-go-server/internal/http2"
 	"github.com/aringq10/http-go-server/internal/request"
 	"github.com/aringq10/http-go-server/internal/response"
 )
@@ -12,20 +21,74 @@ type Server struct {
     handler Handler
     listener net.Listener
     closed bool
+    config Config
+    ctx context.Context
+    cancel context.CancelFunc
 }
 
+type Config struct {
+    // HandlerTimeout cancels the request context once it elapses, 0 means no timeout
+    HandlerTimeout time.Duration
+    // TLSConfig serves HTTPS when set, ALPN picks h2 or http/1.1 per connection
+    TLSConfig *tls.Config
+    // ErrorPages renders the HTML variant of error responses, built-in
+    // pages are used when nil
+    ErrorPages *response.ErrorPages
+    // HandshakeTimeout bounds the TLS handshake, 0 means DefaultHandshakeTimeout
+    HandshakeTimeout time.Duration
+    // StreamBodies leaves HTTP/1.1 request bodies on the connection for
+    // Request.BodyReader instead of buffering them into Body. Disconnects
+    // are only noticed once the handler has read the whole body.
+    StreamBodies bool
+    // MaxBodySize answers requests whose body is larger with 413, 0 means
+    // DefaultMaxBodySize and a negative value no limit
+    MaxBodySize int64
+}
+
+const DefaultHandshakeTimeout = 10 * time.Second
+const DefaultMaxBodySize = 10 << 20
+
+const bufferSize = 4096
+const maxWatchedBytes = 64 * 1024
+// h2c upgrade requests with a larger body are answered over HTTP/1.1
+const maxUpgradeBodySize = 64 * 1024
+
 type Handler func(w *response.Writer, req *request.Request)
 
 func Serve(port uint16, handler Handler) (*Server, error) {
+    return ServeConfig(port, handler, Config{})
+}
+
+func ServeConfig(port uint16, handler Handler, config Config) (*Server, error) {
     listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
     if err != nil {
         return nil, err
     }
 
+    return ServeListener(listener, handler, config)
+}
+
+// ServeListener serves the connections accepted by listener, e.g. one
+// bound to a specific address or an in-memory one in tests. Close closes
+// the listener.
+func ServeListener(listener net.Listener, handler Handler, config Config) (*Server, error) {
+    if config.TLSConfig != nil {
+        tlsConfig := config.TLSConfig.Clone()
+        if len(tlsConfig.NextProtos) == 0 {
+            tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+        }
+        listener = tls.NewListener(listener, tlsConfig)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+
     s := &Server{
         handler: handler,
         listener: listener,
         closed: false,
+        config: config,
+        ctx: ctx,
+        cancel: cancel,
     }
 
     go s.listen()
@@ -33,8 +96,26 @@ func Serve(port uint16, handler Handler) (*Server, error) {
     return s, nil
 }
 
+func ServeTLS(port uint16, handler Handler, certFile string, keyFile string) (*Server, error) {
+    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        return nil, err
+    }
+
+    config := Config{
+        TLSConfig: &tls.Config{ Certificates: []tls.Certificate{cert} },
+    }
+
+    return ServeConfig(port, handler, config)
+}
+
+func (s *Server) Addr() net.Addr {
+    return s.listener.Addr()
+}
+
 func (s *Server) Close() error {
     s.closed = true
+    s.cancel()
     return s.listener.Close()
 }
 
@@ -55,21 +136,228 @@ func (s *Server) listen() {
     }
 }
 
-func (s *Server) handle(conn net.Conn) {
-    defer conn.Close()
+func (s *Server) handle(rawConn net.Conn) {
+    hijacked := false
+    defer func() {
+        if !hijacked {
+            rawConn.Close()
+        }
+    }()
 
-    responseWriter := response.NewWriter(conn)
-    req, err := request.RequestFromReader(conn)
+    if tlsConn, ok := rawConn.(*tls.Conn); ok {
+        // A client stalling the handshake mustn't hold the goroutine forever
+        timeout := s.config.HandshakeTimeout
+        if timeout == 0 {
+            timeout = DefaultHandshakeTimeout
+        }
+        tlsConn.SetDeadline(time.Now().Add(timeout))
+        if err := tlsConn.Handshake(); err != nil {
+            fmt.Printf("error during TLS handshake: %s\n", err.Error())
+            return
+        }
+        tlsConn.SetDeadline(time.Time{})
+        if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+            s.h2().ServeConn(tlsConn, tlsConn)
+            return
+        }
+        hijacked = s.serveHTTP1(tlsConn, false)
+        return
+    }
 
+    conn, err := sniff(rawConn, len("PRI"))
     if err != nil {
-        body := fmt.Sprintf("That's such a bad request tho...\n  %v\n", err.Error())
-        responseWriter.WriteStatusLine(400)
-        h := response.GetDefaultHeaders(0)
-        h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
-        responseWriter.WriteHeaders(h)
-        responseWriter.WriteBody([]byte(body))
         return
     }
+    if bytes.HasPrefix(conn.prefix, []byte("PRI")) {
+        // Prior knowledge HTTP/2, the client starts with the preface
+        s.h2().ServeConn(conn, conn)
+        return
+    }
+
+    hijacked = s.serveHTTP1(conn, true)
+}
+
+// serveHTTP1 answers a single HTTP/1.1 request, allowH2C permits the
+// cleartext upgrade to HTTP/2. It reports whether the handler hijacked
+// the connection.
+func (s *Server) serveHTTP1(conn net.Conn, allowH2C bool) (hijacked bool) {
+    req, err := request.RequestFromReaderOptions(conn, request.ReadOptions{
+        MaxBodySize: s.maxBodySize(),
+        StreamBody: s.config.StreamBodies,
+    })
+
+    if err != nil {
+        // Once the headers are in the problem can follow their Accept,
+        // before that there's nothing to go by
+        var parseErr *request.ParseError
+        var partial *request.Request
+        if errors.As(err, &parseErr) {
+            partial = parseErr.Request
+        }
+        status := 400
+        if errors.Is(err, request.ErrBodyTooLarge) {
+            status = 413
+        }
+        responseWriter := response.NewWriter(conn)
+        responseWriter.SetErrorPages(s.config.ErrorPages)
+        responseWriter.WriteProblem(partial, response.NewProblem(status, err.Error()), nil)
+        return false
+    }
+
+    // The body has to be off the connection before it speaks HTTP/2
+    if allowH2C && http2.IsUpgradeRequest(req) && req.ReadBody(maxUpgradeBodySize) == nil {
+        response.NewWriter(conn).WriteSwitchingProtocols("h2c", nil)
+        r := io.MultiReader(bytes.NewReader(req.Buffered()), conn)
+        s.h2().ServeUpgrade(conn, r, req)
+        return false
+    }
+
+    ctx, cancel := s.requestContext()
+    defer cancel()
+
+    // The connection is only watched once the body is off it
+    var watchMu sync.Mutex
+    var watcher *connWatcher
+    req.OnBodyRead(func() {
+        watchMu.Lock()
+        defer watchMu.Unlock()
+        if !hijacked {
+            watcher = watchConn(conn, cancel)
+        }
+    })
+
+    responseWriter := response.NewHijackableWriter(conn, func() (net.Conn, []byte, error) {
+        watchMu.Lock()
+        defer watchMu.Unlock()
+        buffered := append([]byte{}, req.Buffered()...)
+        if watcher != nil {
+            buffered = append(buffered, watcher.stop()...)
+        }
+        hijacked = true
+        return conn, buffered, nil
+    })
+    responseWriter.SetErrorPages(s.config.ErrorPages)
+
+    s.handler(responseWriter, req.WithContext(ctx))
+    req.Cleanup()
+
+    if errors.Is(ctx.Err(), context.DeadlineExceeded) && !responseWriter.Written() {
+        responseWriter.WriteProblem(req, response.NewProblem(503, "the request took too long to handle"), nil)
+    }
+
+    return hijacked
+}
+
+func (s *Server) maxBodySize() int64 {
+    switch {
+    case s.config.MaxBodySize == 0:
+        return DefaultMaxBodySize
+    case s.config.MaxBodySize < 0:
+        return 0
+    }
+    return s.config.MaxBodySize
+}
+
+func (s *Server) h2() *http2.Server {
+    return &http2.Server{
+        Handler: http2.Handler(s.handler),
+        Context: s.ctx,
+        HandlerTimeout: s.config.HandlerTimeout,
+        ErrorPages: s.config.ErrorPages,
+        MaxBodySize: s.maxBodySize(),
+    }
+}
+
+func (s *Server) requestContext() (context.Context, context.CancelFunc) {
+    if s.config.HandlerTimeout > 0 {
+        return context.WithTimeout(s.ctx, s.config.HandlerTimeout)
+    }
+    return context.WithCancel(s.ctx)
+}
+
+// connWatcher cancels the request context once the client closes its
+// side of the connection. The server answers one request per connection,
+// so anything the client sends meanwhile only matters after a hijack.
+type connWatcher struct {
+    conn     net.Conn
+    cancel   context.CancelFunc
+    stopping atomic.Bool
+    done     chan struct{}
+    extra    []byte
+}
+
+func watchConn(conn net.Conn, cancel context.CancelFunc) *connWatcher {
+    cw := &connWatcher{
+        conn: conn,
+        cancel: cancel,
+        done: make(chan struct{}),
+    }
+    go cw.watch()
+    return cw
+}
+
+func (cw *connWatcher) watch() {
+    defer close(cw.done)
+
+    buf := make([]byte, 512)
+    for {
+        // Once the buffer is full the client gets no more reads until a
+        // hijack takes over, so nothing it sends is dropped. Disconnects
+        // go unnoticed from then on.
+        space := maxWatchedBytes - len(cw.extra)
+        if space <= 0 {
+            return
+        }
+        n, err := cw.conn.Read(buf[:min(len(buf), space)])
+        cw.extra = append(cw.extra, buf[:n]...)
+        if err != nil {
+            if !cw.stopping.Load() {
+                cw.cancel()
+            }
+            return
+        }
+    }
+}
+
+// stop interrupts the pending read and returns what the client sent
+// while being watched
+func (cw *connWatcher) stop() []byte {
+    cw.stopping.Store(true)
+    cw.conn.SetReadDeadline(time.Now())
+    <-cw.done
+    cw.conn.SetReadDeadline(time.Time{})
+    return cw.extra
+}
+
+// sniffedConn replays the bytes read while picking the protocol
+type sniffedConn struct {
+    net.Conn
+    prefix []byte
+    r io.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+    return c.r.Read(p)
+}
+
+// sniff reads at least n bytes, or as many as the client sends before
+// closing, without losing them for later reads.
+func sniff(conn net.Conn, n int) (*sniffedConn, error) {
+    buf := make([]byte, 0, bufferSize)
+    for len(buf) < n {
+        m, err := conn.Read(buf[len(buf):cap(buf)])
+        buf = buf[:len(buf) + m]
+        if err != nil {
+            if len(buf) == 0 {
+                return nil, err
+            }
+            break
+        }
+    }
 
-    s.handler(responseWriter, req)
+    return &sniffedConn{
+        Conn: conn,
+        prefix: buf,
+        r: io.MultiReader(bytes.NewReader(buf), conn),
+    }, nil
 }
@@ -0,0 +1,445 @@
+package server
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math/big"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "testing"
+    "testing/fstest"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/http2"
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    require.NoError(t, err)
+
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(1),
+        Subject: pkix.Name{CommonName: "localhost"},
+        DNSNames: []string{"localhost"},
+        NotBefore: time.Now().Add(-time.Hour),
+        NotAfter: time.Now().Add(time.Hour),
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    require.NoError(t, err)
+
+    return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServeTLSNegotiatesProtocol(t *testing.T) {
+    handler := func(w *response.Writer, req *request.Request) {
+        w.WriteHttpMessage(200, response.GetDefaultHeaders(0), []byte(req.RequestLine.HttpVersion))
+    }
+    config := Config{
+        TLSConfig: &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}},
+    }
+    s, err := ServeConfig(0, handler, config)
+    require.NoError(t, err)
+    defer s.Close()
+
+    url := "https://" + s.Addr().String() + "/"
+
+    // Test: Client offering h2 gets HTTP/2
+    client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: true}}
+    resp, err := client.Get(url)
+    require.NoError(t, err)
+    body, err := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    require.NoError(t, err)
+    assert.Equal(t, 2, resp.ProtoMajor)
+    assert.Equal(t, "2", string(body))
+
+    // Test: Client offering only http/1.1 gets HTTP/1.1
+    client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+    resp, err = client.Get(url)
+    require.NoError(t, err)
+    body, err = io.ReadAll(resp.Body)
+    resp.Body.Close()
+    require.NoError(t, err)
+    assert.Equal(t, 1, resp.ProtoMajor)
+    assert.Equal(t, "1.1", string(body))
+}
+
+func TestServeTLSHandshakeTimeout(t *testing.T) {
+    handler := func(w *response.Writer, req *request.Request) {
+        w.WriteHttpMessage(200, response.GetDefaultHeaders(0), nil)
+    }
+    config := Config{
+        TLSConfig: &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}},
+        HandshakeTimeout: 100 * time.Millisecond,
+    }
+    s, err := ServeConfig(0, handler, config)
+    require.NoError(t, err)
+    defer s.Close()
+
+    // Test: A client that never starts the handshake gets disconnected
+    conn, err := net.Dial("tcp", s.Addr().String())
+    require.NoError(t, err)
+    defer conn.Close()
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    start := time.Now()
+    _, err = conn.Read(make([]byte, 1))
+    assert.ErrorIs(t, err, io.EOF)
+    assert.Less(t, time.Since(start), 5 * time.Second)
+}
+
+func TestServerProblems(t *testing.T) {
+    handler := func(w *response.Writer, req *request.Request) {
+        if req.URL.Path == "/slow" {
+            <-req.Context().Done()
+            return
+        }
+        w.WriteHttpMessage(200, response.GetDefaultHeaders(0), []byte("ok"))
+    }
+    s, err := ServeConfig(0, handler, Config{HandlerTimeout: 50 * time.Millisecond})
+    require.NoError(t, err)
+    defer s.Close()
+
+    // Test: Parse errors are problem+json
+    conn, err := net.Dial("tcp", s.Addr().String())
+    require.NoError(t, err)
+    conn.Write([]byte("GARBAGE\r\n\r\n"))
+    resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+    conn.Close()
+    require.NoError(t, err)
+    assert.Equal(t, 400, resp.StatusCode)
+    assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+    problem := map[string]any{}
+    require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+    assert.Equal(t, "Bad Request", problem["title"])
+    assert.Equal(t, float64(400), problem["status"])
+    assert.NotEmpty(t, problem["detail"])
+
+    // Test: Timeouts are 503, as HTML for browsers
+    req, err := http.NewRequest("GET", "http://" + s.Addr().String() + "/slow", nil)
+    require.NoError(t, err)
+    req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+    resp, err = http.DefaultClient.Do(req)
+    require.NoError(t, err)
+    body, err := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    require.NoError(t, err)
+    assert.Equal(t, 503, resp.StatusCode)
+    assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+    assert.Contains(t, string(body), "<h1>Service Unavailable</h1>")
+}
+
+func TestServerErrorPages(t *testing.T) {
+    pages, err := response.LoadErrorPages(fstest.MapFS{
+        "404.html": {Data: []byte(`{{template "who" .}} lost {{.Path}}`)},
+        "4xx.html": {Data: []byte(`client {{.Status}} {{.Title}}`)},
+        "error.html": {Data: []byte(`fallback {{.Status}} for {{.RequestID}}`)},
+        "_who.html": {Data: []byte(`{{define "who"}}{{.Method}}{{end}}`)},
+    })
+    require.NoError(t, err)
+
+    handler := func(w *response.Writer, req *request.Request) {
+        status, _ := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/"))
+        w.WriteProblem(req, response.NewProblem(status, ""), nil)
+    }
+    s, err := ServeConfig(0, handler, Config{ErrorPages: pages})
+    require.NoError(t, err)
+    defer s.Close()
+
+    get := func(target string) string {
+        req, err := http.NewRequest("GET", "http://" + s.Addr().String() + target, nil)
+        require.NoError(t, err)
+        req.Header.Set("Accept", "text/html")
+        req.Header.Set("X-Request-Id", "req-42")
+        resp, err := http.DefaultClient.Do(req)
+        require.NoError(t, err)
+        body, err := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        require.NoError(t, err)
+        return string(body)
+    }
+
+    // Test: Exact status, class and fallback pages
+    assert.Equal(t, "GET lost /404", get("/404"))
+    assert.Equal(t, "client 405 Method Not Allowed", get("/405"))
+    assert.Equal(t, "fallback 500 for req-42", get("/500"))
+}
+
+func TestServerHijack(t *testing.T) {
+    payload := bytes.Repeat([]byte("0123456789"), 10000)
+    handler := func(w *response.Writer, req *request.Request) {
+        // Let the client get well ahead, past what the server buffers
+        time.Sleep(100 * time.Millisecond)
+        h := headers.NewHeaders()
+        h.Set("X-Protocol", "echo")
+        if err := w.WriteSwitchingProtocols("echo", h); err != nil {
+            return
+        }
+        conn, buffered, err := w.Hijack()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        echoed := make([]byte, len(payload))
+        if _, err := io.ReadFull(io.MultiReader(bytes.NewReader(buffered), conn), echoed); err != nil {
+            return
+        }
+        conn.Write(echoed)
+    }
+    s, err := ServeConfig(0, handler, Config{})
+    require.NoError(t, err)
+    defer s.Close()
+
+    conn, err := net.Dial("tcp", s.Addr().String())
+    require.NoError(t, err)
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+    // Test: Bytes pipelined after the request and sent while the handler
+    // runs all reach the hijacker, well past the watcher's buffer
+    raw := "GET /echo HTTP/1.1\r\nHost: localhost:42069\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"
+    go conn.Write(append([]byte(raw), payload...))
+
+    // Test: 101 Switching Protocols with the upgrade headers
+    reader := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(reader, nil)
+    require.NoError(t, err)
+    assert.Equal(t, 101, resp.StatusCode)
+    assert.Equal(t, "101 Switching Protocols", resp.Status)
+    assert.Equal(t, "Upgrade", resp.Header.Get("Connection"))
+    assert.Equal(t, "echo", resp.Header.Get("Upgrade"))
+    assert.Equal(t, "echo", resp.Header.Get("X-Protocol"))
+
+    echoed, err := io.ReadAll(reader)
+    require.NoError(t, err)
+    assert.Equal(t, len(payload), len(echoed))
+    assert.True(t, bytes.Equal(payload, echoed))
+}
+
+func TestServerClientDisconnect(t *testing.T) {
+    canceled := make(chan error, 1)
+    handler := func(w *response.Writer, req *request.Request) {
+        select {
+        case <-req.Context().Done():
+            canceled <- req.Context().Err()
+        case <-time.After(5 * time.Second):
+            canceled <- nil
+        }
+    }
+    s := &Server{handler: handler, ctx: context.Background()}
+    serverConn, clientConn := net.Pipe()
+    go s.handle(serverConn)
+
+    // Test: The request context is canceled once the client goes away
+    _, err := clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+    require.NoError(t, err)
+    clientConn.Close()
+    assert.ErrorIs(t, <-canceled, context.Canceled)
+}
+
+func TestServerStreamBodies(t *testing.T) {
+    canceled := make(chan error, 1)
+    handler := func(w *response.Writer, req *request.Request) {
+        buffered := len(req.Body)
+        n, err := io.Copy(io.Discard, req.BodyReader())
+        if err != nil {
+            w.WriteProblem(req, response.NewProblem(400, err.Error()), nil)
+            return
+        }
+        if req.URL.Path == "/wait" {
+            select {
+            case <-req.Context().Done():
+                canceled <- req.Context().Err()
+            case <-time.After(5 * time.Second):
+                canceled <- nil
+            }
+            return
+        }
+        w.WriteHttpMessage(200, response.GetDefaultHeaders(0), []byte(fmt.Sprintf("%d %d", buffered, n)))
+    }
+    s, err := ServeConfig(0, handler, Config{StreamBodies: true})
+    require.NoError(t, err)
+    defer s.Close()
+    url := "http://" + s.Addr().String()
+
+    // Test: The handler reads the body off the connection
+    resp, err := http.Post(url + "/upload", "application/octet-stream", bytes.NewReader(bytes.Repeat([]byte("x"), 1 << 20)))
+    require.NoError(t, err)
+    body, err := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    require.NoError(t, err)
+    assert.Equal(t, 200, resp.StatusCode)
+    assert.Equal(t, fmt.Sprintf("0 %d", 1 << 20), string(body))
+
+    // Test: Disconnects are noticed once the body has been read
+    conn, err := net.Dial("tcp", s.Addr().String())
+    require.NoError(t, err)
+    _, err = conn.Write([]byte("POST /wait HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 4\r\n\r\nbody"))
+    require.NoError(t, err)
+    conn.Close()
+    assert.ErrorIs(t, <-canceled, context.Canceled)
+}
+
+func TestServerParseErrors(t *testing.T) {
+    handler := func(w *response.Writer, req *request.Request) {
+        io.Copy(io.Discard, req.BodyReader())
+        w.WriteHttpMessage(200, response.GetDefaultHeaders(0), []byte("ok"))
+    }
+    s, err := ServeConfig(0, handler, Config{MaxBodySize: 16})
+    require.NoError(t, err)
+    defer s.Close()
+
+    send := func(raw string) *http.Response {
+        conn, err := net.Dial("tcp", s.Addr().String())
+        require.NoError(t, err)
+        defer conn.Close()
+        conn.Write([]byte(raw))
+        conn.(*net.TCPConn).CloseWrite()
+        resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+        require.NoError(t, err)
+        io.ReadAll(resp.Body)
+        resp.Body.Close()
+        return resp
+    }
+
+    // Test: Bodies past the limit are 413, negotiated once headers parsed
+    resp := send("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 17\r\n\r\n")
+    assert.Equal(t, 413, resp.StatusCode)
+    assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+    resp = send("POST / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/html\r\nContent-Length: 17\r\n\r\n")
+    assert.Equal(t, 413, resp.StatusCode)
+    assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+    // Test: Bodies within the limit are fine
+    resp = send("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 16\r\n\r\n0123456789abcdef")
+    assert.Equal(t, 200, resp.StatusCode)
+
+    // Test: A short body is a 400 in the format the headers asked for
+    resp = send("POST / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/html\r\nContent-Length: 10\r\n\r\nabc")
+    assert.Equal(t, 400, resp.StatusCode)
+    assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+    // Test: Broken headers leave nothing to negotiate with
+    resp = send("GET / HTTP/1.1\r\nAccept: text/html\r\nBroken header\r\n\r\n")
+    assert.Equal(t, 400, resp.StatusCode)
+    assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+    // Test: The limit can be lifted
+    s2, err := ServeConfig(0, handler, Config{MaxBodySize: -1, StreamBodies: true})
+    require.NoError(t, err)
+    defer s2.Close()
+    httpResp, err := http.Post("http://" + s2.Addr().String() + "/", "text/plain", strings.NewReader(strings.Repeat("x", DefaultMaxBodySize + 1)))
+    require.NoError(t, err)
+    httpResp.Body.Close()
+    assert.Equal(t, 200, httpResp.StatusCode)
+}
+
+func TestBuiltinErrors(t *testing.T) {
+    handler := Chain(NotFound, AllowMethods("GET", "POST"))
+    s, err := ServeConfig(0, handler, Config{})
+    require.NoError(t, err)
+    defer s.Close()
+
+    do := func(method string, accept string) *http.Response {
+        req, err := http.NewRequest(method, "http://" + s.Addr().String() + "/missing", nil)
+        require.NoError(t, err)
+        if accept != "" {
+            req.Header.Set("Accept", accept)
+        }
+        resp, err := http.DefaultClient.Do(req)
+        require.NoError(t, err)
+        io.ReadAll(resp.Body)
+        resp.Body.Close()
+        return resp
+    }
+
+    // Test: 404 as JSON or HTML
+    resp := do("GET", "")
+    assert.Equal(t, 404, resp.StatusCode)
+    assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+    resp = do("GET", "text/html")
+    assert.Equal(t, 404, resp.StatusCode)
+    assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+    // Test: HEAD comes with GET, other methods get 405 with Allow
+    resp = do("HEAD", "")
+    assert.Equal(t, 404, resp.StatusCode)
+    resp = do("DELETE", "text/html")
+    assert.Equal(t, 405, resp.StatusCode)
+    assert.Equal(t, "GET, POST, HEAD", resp.Header.Get("Allow"))
+    assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+}
+
+func TestServeH2CUpgrade(t *testing.T) {
+    handler := func(w *response.Writer, req *request.Request) {
+        body := fmt.Sprintf("%s %s %s", req.RequestLine.Method, req.URL.Path, req.Body)
+        w.WriteHttpMessage(200, response.GetDefaultHeaders(0), []byte(body))
+    }
+    s, err := ServeConfig(0, handler, Config{})
+    require.NoError(t, err)
+    defer s.Close()
+
+    conn, err := net.Dial("tcp", s.Addr().String())
+    require.NoError(t, err)
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+    // SETTINGS_ENABLE_PUSH = 0
+    raw := "POST /h2c HTTP/1.1\r\nHost: localhost:42069\r\n" +
+        "Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAIAAAAA\r\n" +
+        "Content-Length: 5\r\n\r\nhello"
+    _, err = conn.Write([]byte(raw))
+    require.NoError(t, err)
+
+    // Test: 101 Switching Protocols to h2c
+    resp, err := response.FromReader(conn, nil)
+    require.NoError(t, err)
+    assert.Equal(t, 101, resp.StatusLine.StatusCode)
+    assert.Equal(t, "h2c", resp.Headers.Get("Upgrade"))
+
+    _, err = conn.Write([]byte(http2.ClientPreface))
+    require.NoError(t, err)
+    err = http2.WriteFrame(conn, http2.SettingsFrame(nil))
+    require.NoError(t, err)
+
+    // Test: The upgraded request is answered on stream 1 over HTTP/2
+    r := io.MultiReader(bytes.NewReader(resp.Buffered()), conn)
+    decoder := http2.NewDecoder(4096)
+    var fields []http2.HeaderField
+    var body []byte
+    for {
+        f, err := http2.ReadFrame(r, 16384)
+        require.NoError(t, err)
+        if f.StreamID != 1 {
+            continue
+        }
+        switch f.Type {
+        case http2.FrameHeaders:
+            fields, err = decoder.Decode(f.Payload)
+            require.NoError(t, err)
+        case http2.FrameData:
+            body = append(body, f.Payload...)
+        }
+        if f.Has(http2.FlagEndStream) {
+            break
+        }
+    }
+    assert.Contains(t, fields, http2.HeaderField{Name: ":status", Value: "200"})
+    assert.Equal(t, "POST /h2c hello", string(body))
+}
@@ -0,0 +1,104 @@
+package servertest
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "strings"
+
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+)
+
+// ResponseRecorder captures what a handler writes without any connection
+// or wire format. Pass its Writer to the handler, then inspect the fields.
+// The body is recorded as it leaves the Writer, after filters like
+// compression but without chunked framing.
+type ResponseRecorder struct {
+    *response.Writer
+    // Code is the status, 0 until headers are written
+    Code     int
+    Headers  headers.Headers
+    Body     *bytes.Buffer
+    Trailers headers.Headers
+    // Done reports whether the response was ended, not just abandoned
+    Done bool
+}
+
+func NewRecorder() *ResponseRecorder {
+    rec := &ResponseRecorder{
+        Headers: headers.NewHeaders(),
+        Body: &bytes.Buffer{},
+        Trailers: headers.NewHeaders(),
+    }
+    rec.Writer = response.NewSinkWriter(recorderSink{rec})
+    return rec
+}
+
+// recorderSink implements response.Sink for a ResponseRecorder
+type recorderSink struct {
+    rec *ResponseRecorder
+}
+
+func (rs recorderSink) WriteHeader(statusCode int, h headers.Headers) error {
+    if rs.rec.Code != 0 {
+        return fmt.Errorf("headers already written with status %d", rs.rec.Code)
+    }
+    if statusCode == 0 {
+        statusCode = 200
+    }
+    rs.rec.Code = statusCode
+    for key, value := range h {
+        rs.rec.Headers[key] = value
+    }
+    return nil
+}
+
+func (rs recorderSink) Write(p []byte) (int, error) {
+    if rs.rec.Done {
+        return 0, fmt.Errorf("write after end of response")
+    }
+    if rs.rec.Code == 0 {
+        rs.rec.Code = 200
+    }
+    return rs.rec.Body.Write(p)
+}
+
+func (rs recorderSink) Close(trailers headers.Headers) error {
+    if rs.rec.Code == 0 {
+        rs.rec.Code = 200
+    }
+    for key, value := range trailers {
+        rs.rec.Trailers[key] = value
+    }
+    rs.rec.Done = true
+    return nil
+}
+
+// NewRequest parses a request for target as the server would, with a
+// Host header and the Content-Length of body, which may be nil. It panics
+// if the request doesn't parse, as that's a mistake in the test.
+func NewRequest(method string, target string, body io.Reader) *request.Request {
+    content := []byte{}
+    if body != nil {
+        var err error
+        if content, err = io.ReadAll(body); err != nil {
+            panic("servertest: failed to read body: " + err.Error())
+        }
+    }
+
+    raw := &strings.Builder{}
+    fmt.Fprintf(raw, "%v %v HTTP/1.1\r\nHost: localhost:42069\r\n", method, target)
+    if len(content) > 0 {
+        fmt.Fprintf(raw, "Content-Length: %d\r\n", len(content))
+    }
+    raw.WriteString("\r\n")
+    raw.Write(content)
+
+    req, err := request.RequestFromReader(strings.NewReader(raw.String()))
+    if err != nil {
+        panic("servertest: invalid request: " + err.Error())
+    }
+    return req
+}
@@ -0,0 +1,141 @@
+package servertest
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/server"
+)
+
+// Timeout bounds how long RoundTrip waits for the server to answer
+var Timeout = 5 * time.Second
+
+// Server is a server.Server for tests, listening on a random loopback port
+// or entirely in memory.
+type Server struct {
+    // URL is the base URL, e.g. "http://127.0.0.1:54321". In-memory
+    // servers get "http://pipe", which only Client can reach.
+    URL string
+    srv *server.Server
+    listener net.Listener
+}
+
+// NewServer serves handler on a random loopback port.
+func NewServer(handler server.Handler, config server.Config) *Server {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        panic("servertest: failed to listen: " + err.Error())
+    }
+    return start(listener, handler, config, "http://" + listener.Addr().String())
+}
+
+// NewPipeServer serves handler over net.Pipe connections, nothing touches
+// the network.
+func NewPipeServer(handler server.Handler, config server.Config) *Server {
+    listener := &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+    return start(listener, handler, config, "http://pipe")
+}
+
+func start(listener net.Listener, handler server.Handler, config server.Config, url string) *Server {
+    srv, err := server.ServeListener(listener, handler, config)
+    if err != nil {
+        panic("servertest: failed to serve: " + err.Error())
+    }
+    return &Server{URL: url, srv: srv, listener: listener}
+}
+
+// Dial opens a connection to the server.
+func (s *Server) Dial() (net.Conn, error) {
+    if pl, ok := s.listener.(*pipeListener); ok {
+        return pl.dial()
+    }
+    return net.Dial("tcp", s.listener.Addr().String())
+}
+
+// RoundTrip sends rawRequest exactly as given and returns everything the
+// server wrote until it closed the connection, for asserting on the wire
+// format.
+func (s *Server) RoundTrip(rawRequest string) (string, error) {
+    conn, err := s.Dial()
+    if err != nil {
+        return "", err
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(Timeout))
+
+    // Pipes don't buffer, the write only completes once the server reads
+    // everything, which it may not do for a malformed request
+    go conn.Write([]byte(rawRequest))
+
+    resp, err := io.ReadAll(conn)
+    return string(resp), err
+}
+
+// Client returns an http.Client whose connections all go to the server,
+// whatever host the request URL names.
+func (s *Server) Client() *http.Client {
+    return &http.Client{
+        Transport: &http.Transport{
+            DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+                return s.Dial()
+            },
+            DisableKeepAlives: true,
+        },
+    }
+}
+
+func (s *Server) Close() error {
+    return s.srv.Close()
+}
+
+// pipeListener accepts the server ends of the pipes made by dial
+type pipeListener struct {
+    conns chan net.Conn
+    done chan struct{}
+    closeOnce sync.Once
+}
+
+var errListenerClosed = errors.New("servertest: listener closed")
+
+func (pl *pipeListener) Accept() (net.Conn, error) {
+    select {
+    case conn := <-pl.conns:
+        return conn, nil
+    case <-pl.done:
+        return nil, errListenerClosed
+    }
+}
+
+func (pl *pipeListener) dial() (net.Conn, error) {
+    serverConn, clientConn := net.Pipe()
+    select {
+    case pl.conns <- serverConn:
+        return clientConn, nil
+    case <-pl.done:
+        return nil, errListenerClosed
+    }
+}
+
+func (pl *pipeListener) Close() error {
+    pl.closeOnce.Do(func() { close(pl.done) })
+    return nil
+}
+
+func (pl *pipeListener) Addr() net.Addr {
+    return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string {
+    return "pipe"
+}
+
+func (pipeAddr) String() string {
+    return "pipe"
+}
@@ -0,0 +1,125 @@
+package servertest
+
+import (
+    "bufio"
+    "io"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/compress"
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/aringq10/http-go-server/internal/server"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func hello(w *response.Writer, req *request.Request) {
+    h := response.GetDefaultHeaders(0)
+    h.Set("X-Path", req.URL.Path)
+    w.WriteHttpMessage(200, h, []byte("hello " + string(req.Body)))
+}
+
+func TestServers(t *testing.T) {
+    for _, s := range []*Server{NewPipeServer(hello, server.Config{}), NewServer(hello, server.Config{})} {
+        defer s.Close()
+
+        // Test: Exact wire output for raw bytes
+        resp, err := s.RoundTrip("POST /greet HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 4\r\n\r\nlane")
+        require.NoError(t, err)
+        assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+        assert.Contains(t, resp, "x-path: /greet\r\n")
+        assert.Contains(t, resp, "content-length: 10\r\n")
+        assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhello lane"))
+
+        // Test: Parse errors come back too
+        resp, err = s.RoundTrip("GARBAGE\r\n\r\n")
+        require.NoError(t, err)
+        assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+
+        // Test: net/http client through the same connections
+        httpResp, err := s.Client().Get(s.URL + "/client")
+        require.NoError(t, err)
+        body, err := io.ReadAll(httpResp.Body)
+        httpResp.Body.Close()
+        require.NoError(t, err)
+        assert.Equal(t, 200, httpResp.StatusCode)
+        assert.Equal(t, "/client", httpResp.Header.Get("X-Path"))
+        assert.Equal(t, "hello ", string(body))
+    }
+}
+
+func TestRecorder(t *testing.T) {
+    // Test: Status, headers and body
+    rec := NewRecorder()
+    hello(rec.Writer, NewRequest("POST", "/greet", strings.NewReader("lane")))
+    assert.Equal(t, 200, rec.Code)
+    assert.Equal(t, "/greet", rec.Headers.Get("X-Path"))
+    assert.Equal(t, "10", rec.Headers.Get("Content-Length"))
+    assert.Equal(t, "hello lane", rec.Body.String())
+    assert.True(t, rec.Done)
+
+    // Test: Chunked bodies are recorded without framing, trailers separately
+    rec = NewRecorder()
+    rec.WriteChunksFromReader(strings.NewReader("streamed"), response.GetDefaultHeaders(0))
+    assert.Equal(t, "chunked", rec.Headers.Get("Transfer-Encoding"))
+    assert.Equal(t, "streamed", rec.Body.String())
+    assert.Equal(t, "8", rec.Trailers.Get("X-Content-Length"))
+
+    // Test: Filters apply as they would on a connection
+    rec = NewRecorder()
+    req := NewRequest("GET", "/", nil)
+    req.Headers.Set("Accept-Encoding", "gzip")
+    compressed := compress.Middleware(compress.Options{MinSize: 1})(hello)
+    compressed(rec.Writer, req)
+    assert.Equal(t, "gzip", rec.Headers.Get("Content-Encoding"))
+    decoded, err := compress.DecodeBody(rec.Body.Bytes(), "gzip", compress.DefaultMaxDecodedSize)
+    require.NoError(t, err)
+    assert.Equal(t, "hello ", string(decoded))
+
+    // Test: Nothing written
+    rec = NewRecorder()
+    assert.Equal(t, 0, rec.Code)
+    assert.False(t, rec.Done)
+}
+
+func TestServerSSEDisconnect(t *testing.T) {
+    done := make(chan bool, 1)
+    events := func(w *response.Writer, req *request.Request) {
+        sse, err := response.NewSSEWriter(w, req, response.GetDefaultHeaders(0), 0)
+        if err != nil {
+            done <- false
+            return
+        }
+        sse.SendData("hello")
+        select {
+        case <-sse.Done():
+            done <- true
+        case <-time.After(Timeout):
+            done <- false
+        }
+    }
+    s := NewServer(events, server.Config{})
+    defer s.Close()
+
+    conn, err := s.Dial()
+    require.NoError(t, err)
+    conn.SetDeadline(time.Now().Add(Timeout))
+    _, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+    require.NoError(t, err)
+
+    // Test: Events reach the client as they're sent
+    reader := bufio.NewReader(conn)
+    for {
+        line, err := reader.ReadString('\n')
+        require.NoError(t, err)
+        if line == "data: hello\n" {
+            break
+        }
+    }
+
+    // Test: Done closes once the client hangs up, with nothing being sent
+    conn.Close()
+    assert.True(t, <-done)
+}
@@ -0,0 +1,326 @@
+package session
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "errors"
+    "log"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/cookie"
+    "github.com/aringq10/http-go-server/internal/headers"
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/aringq10/http-go-server/internal/server"
+)
+
+const (
+    DefaultCookieName      = "session"
+    DefaultIdleTimeout     = 30 * time.Minute
+    DefaultAbsoluteTimeout = 12 * time.Hour
+)
+
+const idLen = 32
+
+// Replaced in tests
+var now = time.Now
+
+type contextKey struct{}
+
+type Options struct {
+    // Store defaults to a MemoryStore
+    Store Store
+    // Secret signs session IDs. If empty a random one is generated, so
+    // sessions won't survive a restart.
+    Secret []byte
+
+    CookieName string
+    // CookiePath defaults to "/"
+    CookiePath string
+    Domain     string
+    Secure     bool
+    // SameSite defaults to Lax
+    SameSite   cookie.SameSite
+
+    // IdleTimeout ends sessions not used for this long
+    IdleTimeout     time.Duration
+    // AbsoluteTimeout ends sessions this long after they were created,
+    // however active they are
+    AbsoluteTimeout time.Duration
+}
+
+// Session is the data a client carries between requests. It is only
+// written to the store once something is set.
+type Session struct {
+    mu         sync.Mutex
+    id         string
+    values     map[string]string
+    created    time.Time
+    // IDs to remove from the store when the session is saved
+    stale      []string
+    isNew      bool
+    changed    bool
+    // The client holds a cookie for id
+    issued     bool
+    // The client sent a session cookie, valid or not
+    sentCookie bool
+    // LastSeen was already refreshed for this request
+    refreshed  bool
+    destroyed  bool
+}
+
+// FromRequest returns the session the middleware attached to req, or nil
+// if there is none.
+func FromRequest(req *request.Request) *Session {
+    s, _ := req.Context().Value(contextKey{}).(*Session)
+    return s
+}
+
+func (s *Session) ID() string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.id
+}
+
+func (s *Session) Get(key string) (string, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    value, ok := s.values[key]
+    return value, ok
+}
+
+func (s *Session) Set(key string, value string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.values[key] = value
+    s.changed = true
+}
+
+func (s *Session) Delete(key string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.values, key)
+    s.changed = true
+}
+
+// Regenerate moves the session to a new ID, keeping its values. Call it
+// whenever privileges change, e.g. on login, so an ID planted or leaked
+// beforehand becomes useless.
+func (s *Session) Regenerate() error {
+    id, err := newID()
+    if err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if !s.isNew {
+        s.stale = append(s.stale, s.id)
+    }
+    s.id = id
+    s.isNew = true
+    s.issued = false
+    s.changed = true
+    return nil
+}
+
+// Destroy removes the session from the store and the client, e.g. on logout.
+func (s *Session) Destroy() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.destroyed = true
+    s.values = map[string]string{}
+}
+
+type manager struct {
+    opts Options
+}
+
+// Middleware loads the session for each request and saves it once the
+// handler is done. The session cookie is sent with the response headers,
+// so regenerating after they've been written has no effect on the client.
+func Middleware(opts Options) server.Middleware {
+    if opts.Store == nil {
+        opts.Store = NewMemoryStore()
+    }
+    if len(opts.Secret) == 0 {
+        opts.Secret = make([]byte, 32)
+        if _, err := rand.Read(opts.Secret); err != nil {
+            panic(err)
+        }
+    }
+    if opts.CookieName == "" {
+        opts.CookieName = DefaultCookieName
+    }
+    if opts.CookiePath == "" {
+        opts.CookiePath = "/"
+    }
+    if opts.SameSite == cookie.SameSiteDefault {
+        opts.SameSite = cookie.SameSiteLax
+    }
+    if opts.IdleTimeout == 0 {
+        opts.IdleTimeout = DefaultIdleTimeout
+    }
+    if opts.AbsoluteTimeout == 0 {
+        opts.AbsoluteTimeout = DefaultAbsoluteTimeout
+    }
+    m := &manager{opts: opts}
+
+    return func(next server.Handler) server.Handler {
+        return func(w *response.Writer, req *request.Request) {
+            s, err := m.load(req)
+            if err != nil {
+                log.Println("session:", err)
+                w.WriteProblem(req, response.NewProblem(500, ""), nil)
+                return
+            }
+
+            w.AddFilter(func(statusCode int, h headers.Headers) response.BodyEncoder {
+                m.save(s, h)
+                return nil
+            })
+            next(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, s)))
+
+            // Pick up changes made after the headers went out
+            m.save(s, nil)
+        }
+    }
+}
+
+// load finds the session for the request's cookie or starts a new one
+func (m *manager) load(req *request.Request) (*Session, error) {
+    value, ok := req.Cookie(m.opts.CookieName)
+    if ok {
+        if id, valid := m.verify(value); valid {
+            rec, err := m.opts.Store.Load(id)
+            switch {
+            case err == nil && !m.expired(rec):
+                if rec.Values == nil {
+                    rec.Values = map[string]string{}
+                }
+                return &Session{id: id, values: rec.Values, created: rec.Created, issued: true, sentCookie: true}, nil
+            case err == nil:
+                if err := m.opts.Store.Delete(id); err != nil {
+                    return nil, err
+                }
+            case !errors.Is(err, ErrNotFound):
+                return nil, err
+            }
+        }
+    }
+
+    id, err := newID()
+    if err != nil {
+        return nil, err
+    }
+    return &Session{id: id, values: map[string]string{}, created: now(), isNew: true, sentCookie: ok}, nil
+}
+
+func (m *manager) expired(rec Record) bool {
+    t := now()
+    return t.Sub(rec.LastSeen) > m.opts.IdleTimeout || t.Sub(rec.Created) > m.opts.AbsoluteTimeout
+}
+
+// save writes s to the store and, when h is not nil, adds the cookie
+// changes to the response headers
+func (m *manager) save(s *Session, h headers.Headers) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, id := range s.stale {
+        if err := m.opts.Store.Delete(id); err != nil {
+            log.Println("session:", err)
+        }
+    }
+    s.stale = nil
+
+    if s.destroyed {
+        if !s.isNew {
+            if err := m.opts.Store.Delete(s.id); err != nil {
+                log.Println("session:", err)
+            }
+            s.isNew = true
+        }
+        if h != nil && s.sentCookie {
+            m.setCookie(h, "", -1)
+        }
+        return
+    }
+
+    // New sessions stay out of the store until they hold something, a
+    // client presenting a dead ID just has its cookie removed
+    if s.isNew && !s.changed {
+        if h != nil && s.sentCookie {
+            m.setCookie(h, "", -1)
+        }
+        return
+    }
+
+    if !s.changed && s.refreshed {
+        return
+    }
+
+    // Saving refreshes the idle timer even when nothing changed
+    t := now()
+    ttl := min(m.opts.IdleTimeout, s.created.Add(m.opts.AbsoluteTimeout).Sub(t))
+    rec := Record{Values: s.values, Created: s.created, LastSeen: t}
+    if err := m.opts.Store.Save(s.id, rec, ttl); err != nil {
+        log.Println("session:", err)
+        return
+    }
+    s.isNew = false
+    s.changed = false
+    s.refreshed = true
+
+    if h != nil && !s.issued {
+        m.setCookie(h, m.sign(s.id), 0)
+        s.issued = true
+    }
+}
+
+func (m *manager) setCookie(h headers.Headers, value string, maxAge int) {
+    c := &cookie.Cookie{
+        Name: m.opts.CookieName,
+        Value: value,
+        Path: m.opts.CookiePath,
+        Domain: m.opts.Domain,
+        MaxAge: maxAge,
+        Secure: m.opts.Secure,
+        HttpOnly: true,
+        SameSite: m.opts.SameSite,
+    }
+    if err := response.SetCookie(h, c); err != nil {
+        log.Println("session:", err)
+        return
+    }
+    // Responses that set a session must never be shared by caches
+    h.Replace("Cache-Control", "no-store")
+}
+
+// sign appends an HMAC of id so IDs can't be guessed or forged
+func (m *manager) sign(id string) string {
+    mac := hmac.New(sha256.New, m.opts.Secret)
+    mac.Write([]byte(id))
+    return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (m *manager) verify(value string) (string, bool) {
+    id, _, ok := strings.Cut(value, ".")
+    if !ok {
+        return "", false
+    }
+    return id, hmac.Equal([]byte(value), []byte(m.sign(id)))
+}
+
+func newID() (string, error) {
+    b := make([]byte, idLen)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,156 @@
+package session
+
+import (
+    "bufio"
+    "bytes"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/aringq10/http-go-server/internal/server"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+// serve runs handler behind mw with the given session cookie value
+func serve(t *testing.T, mw server.Middleware, handler server.Handler, sessionCookie string) *http.Response {
+    raw := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n"
+    if sessionCookie != "" {
+        raw += "Cookie: theme=dark; session=" + sessionCookie + "\r\n"
+    }
+    req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+    require.NoError(t, err)
+
+    buf := &bytes.Buffer{}
+    mw(handler)(response.NewWriter(buf), req)
+
+    resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+    require.NoError(t, err)
+    return resp
+}
+
+// sessionCookie returns the session cookie value set by resp, "" if it
+// was deleted and "none" if it wasn't touched
+func sessionCookie(resp *http.Response) string {
+    for _, c := range resp.Cookies() {
+        if c.Name == DefaultCookieName {
+            if c.MaxAge < 0 {
+                return ""
+            }
+            return c.Value
+        }
+    }
+    return "none"
+}
+
+func handlerFunc(fn func(s *Session)) server.Handler {
+    return func(w *response.Writer, req *request.Request) {
+        s := FromRequest(req)
+        fn(s)
+        value, _ := s.Get("user")
+        w.WriteHttpMessage(200, response.GetDefaultHeaders(0), []byte(value))
+    }
+}
+
+func TestSessionMiddleware(t *testing.T) {
+    store := NewMemoryStore()
+    mw := Middleware(Options{Store: store, Secret: []byte("kakius bakius sikius makius")})
+    nothing := handlerFunc(func(s *Session) {})
+
+    // Test: Untouched session isn't stored or sent
+    resp := serve(t, mw, nothing, "")
+    assert.Equal(t, "none", sessionCookie(resp))
+    assert.Equal(t, 0, store.Len())
+
+    // Test: Setting a value issues a signed HttpOnly cookie
+    resp = serve(t, mw, handlerFunc(func(s *Session) { s.Set("user", "lane") }), "")
+    id := sessionCookie(resp)
+    require.NotEqual(t, "none", id)
+    assert.Contains(t, resp.Header.Get("Set-Cookie"), "HttpOnly")
+    assert.Contains(t, resp.Header.Get("Set-Cookie"), "SameSite=Lax")
+    assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+    assert.Equal(t, 1, store.Len())
+
+    // Test: Cookie brings the session back without being resent
+    resp = serve(t, mw, nothing, id)
+    assert.Equal(t, "none", sessionCookie(resp))
+    body := &bytes.Buffer{}
+    body.ReadFrom(resp.Body)
+    assert.Equal(t, "lane", body.String())
+
+    // Test: Forged signature is rejected and the cookie removed
+    unsigned, _, _ := strings.Cut(id, ".")
+    resp = serve(t, mw, nothing, unsigned + ".forged")
+    assert.Equal(t, "", sessionCookie(resp))
+
+    // Test: Regenerate moves the data to a new ID
+    resp = serve(t, mw, handlerFunc(func(s *Session) { require.NoError(t, s.Regenerate()) }), id)
+    newID := sessionCookie(resp)
+    require.NotEqual(t, "none", newID)
+    assert.NotEqual(t, id, newID)
+    assert.Equal(t, 1, store.Len())
+    resp = serve(t, mw, nothing, id)
+    assert.Equal(t, "", sessionCookie(resp))
+    resp = serve(t, mw, nothing, newID)
+    body.Reset()
+    body.ReadFrom(resp.Body)
+    assert.Equal(t, "lane", body.String())
+
+    // Test: Destroy removes the session everywhere
+    resp = serve(t, mw, handlerFunc(func(s *Session) { s.Destroy() }), newID)
+    assert.Equal(t, "", sessionCookie(resp))
+    assert.Equal(t, 0, store.Len())
+}
+
+func TestSessionTimeouts(t *testing.T) {
+    clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+    now = func() time.Time { return clock }
+    defer func() { now = time.Now }()
+
+    mw := Middleware(Options{IdleTimeout: 10 * time.Minute, AbsoluteTimeout: time.Hour})
+    login := handlerFunc(func(s *Session) { s.Set("user", "prime") })
+    nothing := handlerFunc(func(s *Session) {})
+
+    id := sessionCookie(serve(t, mw, login, ""))
+
+    // Test: Activity keeps the session alive past the idle timeout
+    for i := 0; i < 6; i++ {
+        clock = clock.Add(9 * time.Minute)
+        assert.Equal(t, "none", sessionCookie(serve(t, mw, nothing, id)))
+    }
+
+    // Test: Absolute timeout ends it anyway
+    clock = clock.Add(9 * time.Minute)
+    assert.Equal(t, "", sessionCookie(serve(t, mw, nothing, id)))
+
+    // Test: Idle timeout
+    id = sessionCookie(serve(t, mw, login, ""))
+    clock = clock.Add(11 * time.Minute)
+    assert.Equal(t, "", sessionCookie(serve(t, mw, nothing, id)))
+}
+
+func TestFileStore(t *testing.T) {
+    store, err := NewFileStore(t.TempDir())
+    require.NoError(t, err)
+
+    // Test: Round trip
+    rec := Record{Values: map[string]string{"user": "lane"}, Created: time.Now().UTC(), LastSeen: time.Now().UTC()}
+    require.NoError(t, store.Save("abc", rec, time.Minute))
+    loaded, err := store.Load("abc")
+    require.NoError(t, err)
+    assert.Equal(t, "lane", loaded.Values["user"])
+
+    // Test: Expired record
+    require.NoError(t, store.Save("old", rec, -time.Second))
+    _, err = store.Load("old")
+    assert.ErrorIs(t, err, ErrNotFound)
+
+    // Test: Delete
+    require.NoError(t, store.Delete("abc"))
+    _, err = store.Load("abc")
+    assert.ErrorIs(t, err, ErrNotFound)
+    assert.NoError(t, store.Delete("abc"))
+}
@@ -0,0 +1,213 @@
+package session
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// How often MemoryStore drops expired sessions nobody asked for
+const sweepInterval = time.Minute
+
+var ErrNotFound = errors.New("session not found")
+
+// Record is what a Store keeps for each session.
+type Record struct {
+    Values   map[string]string `json:"values"`
+    Created  time.Time         `json:"created"`
+    LastSeen time.Time         `json:"last_seen"`
+}
+
+// Store persists sessions by ID. Load returns ErrNotFound for unknown or
+// expired IDs and a record should be dropped once its ttl has passed.
+type Store interface {
+    Load(id string) (Record, error)
+    Save(id string, rec Record, ttl time.Duration) error
+    Delete(id string) error
+}
+
+type memoryEntry struct {
+    rec     Record
+    expires time.Time
+}
+
+// MemoryStore keeps sessions in memory, they are lost on restart.
+type MemoryStore struct {
+    mu        sync.Mutex
+    sessions  map[string]memoryEntry
+    lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+    return &MemoryStore{sessions: make(map[string]memoryEntry), lastSweep: now()}
+}
+
+func (ms *MemoryStore) Load(id string) (Record, error) {
+    ms.mu.Lock()
+    defer ms.mu.Unlock()
+
+    entry, ok := ms.sessions[id]
+    if !ok {
+        return Record{}, ErrNotFound
+    }
+    if now().After(entry.expires) {
+        delete(ms.sessions, id)
+        return Record{}, ErrNotFound
+    }
+
+    return Record{Values: copyValues(entry.rec.Values), Created: entry.rec.Created, LastSeen: entry.rec.LastSeen}, nil
+}
+
+func (ms *MemoryStore) Save(id string, rec Record, ttl time.Duration) error {
+    ms.mu.Lock()
+    defer ms.mu.Unlock()
+
+    t := now()
+    rec.Values = copyValues(rec.Values)
+    ms.sessions[id] = memoryEntry{rec: rec, expires: t.Add(ttl)}
+
+    if t.Sub(ms.lastSweep) > sweepInterval {
+        ms.lastSweep = t
+        for id, entry := range ms.sessions {
+            if t.After(entry.expires) {
+                delete(ms.sessions, id)
+            }
+        }
+    }
+
+    return nil
+}
+
+func (ms *MemoryStore) Delete(id string) error {
+    ms.mu.Lock()
+    defer ms.mu.Unlock()
+    delete(ms.sessions, id)
+    return nil
+}
+
+// Len returns the number of sessions held, expired ones included until
+// they are swept.
+func (ms *MemoryStore) Len() int {
+    ms.mu.Lock()
+    defer ms.mu.Unlock()
+    return len(ms.sessions)
+}
+
+// FileStore keeps each session as a JSON file in a directory.
+type FileStore struct {
+    dir string
+}
+
+type fileRecord struct {
+    Record
+    Expires time.Time `json:"expires"`
+}
+
+// NewFileStore creates dir if needed. Files are named after a hash of the
+// session ID, so reading the directory doesn't reveal usable IDs.
+func NewFileStore(dir string) (*FileStore, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, err
+    }
+    return &FileStore{dir: dir}, nil
+}
+
+func (fst *FileStore) Load(id string) (Record, error) {
+    data, err := os.ReadFile(fst.path(id))
+    if errors.Is(err, fs.ErrNotExist) {
+        return Record{}, ErrNotFound
+    }
+    if err != nil {
+        return Record{}, err
+    }
+
+    var fr fileRecord
+    if err := json.Unmarshal(data, &fr); err != nil {
+        return Record{}, fmt.Errorf("error while decoding session file: %w", err)
+    }
+    if now().After(fr.Expires) {
+        os.Remove(fst.path(id))
+        return Record{}, ErrNotFound
+    }
+
+    return fr.Record, nil
+}
+
+func (fst *FileStore) Save(id string, rec Record, ttl time.Duration) error {
+    data, err := json.Marshal(fileRecord{rec, now().Add(ttl)})
+    if err != nil {
+        return err
+    }
+
+    // Write then rename so a concurrent Load never sees half a file
+    tmp, err := os.CreateTemp(fst.dir, ".tmp-*")
+    if err != nil {
+        return err
+    }
+    _, err = tmp.Write(data)
+    if closeErr := tmp.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(tmp.Name(), fst.path(id))
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+    }
+
+    return err
+}
+
+func (fst *FileStore) Delete(id string) error {
+    err := os.Remove(fst.path(id))
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil
+    }
+    return err
+}
+
+// Sweep removes every expired session file.
+func (fst *FileStore) Sweep() error {
+    entries, err := os.ReadDir(fst.dir)
+    if err != nil {
+        return err
+    }
+
+    t := now()
+    for _, entry := range entries {
+        if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+            continue
+        }
+        name := filepath.Join(fst.dir, entry.Name())
+        data, err := os.ReadFile(name)
+        if err != nil {
+            continue
+        }
+        var fr fileRecord
+        if json.Unmarshal(data, &fr) != nil || t.After(fr.Expires) {
+            os.Remove(name)
+        }
+    }
+
+    return nil
+}
+
+func (fst *FileStore) path(id string) string {
+    sum := sha256.Sum256([]byte(id))
+    return filepath.Join(fst.dir, hex.EncodeToString(sum[:]) + ".json")
+}
+
+func copyValues(values map[string]string) map[string]string {
+    c := make(map[string]string, len(values))
+    for k, v := range values {
+        c[k] = v
+    }
+    return c
+}
@@ -0,0 +1,196 @@
+package view
+
+import (
+    "bytes"
+    "fmt"
+    "html/template"
+    "io"
+    "io/fs"
+    "log"
+    "os"
+    "path"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+)
+
+const (
+    layoutsDir  = "layouts"
+    partialsDir = "partials"
+)
+
+type Options struct {
+    // Layout is the file under layouts/ pages are rendered in, e.g.
+    // "base.html". Pages then fill in the blocks it defines. Empty means
+    // pages are rendered on their own.
+    Layout string
+    Funcs  template.FuncMap
+    // Dev reparses the templates whenever a file changes, for use while
+    // editing them
+    Dev bool
+}
+
+// Renderer renders the .html pages of a template tree. Files under
+// layouts/ and partials/ aren't pages, partials are available to every
+// page.
+type Renderer struct {
+    fsys fs.FS
+    opts Options
+
+    mu       sync.RWMutex
+    pages    map[string]*template.Template
+    modTimes map[string]time.Time
+}
+
+// New parses every page in fsys, e.g. an embed.FS sub-tree.
+func New(fsys fs.FS, opts Options) (*Renderer, error) {
+    r := &Renderer{fsys: fsys, opts: opts}
+    if err := r.load(); err != nil {
+        return nil, err
+    }
+    return r, nil
+}
+
+// NewDir parses every page in dir, see New.
+func NewDir(dir string, opts Options) (*Renderer, error) {
+    return New(os.DirFS(dir), opts)
+}
+
+func (r *Renderer) load() error {
+    pageNames := []string{}
+    partials := []string{}
+    modTimes := map[string]time.Time{}
+
+    err := fs.WalkDir(r.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if d.IsDir() || path.Ext(name) != ".html" {
+            return nil
+        }
+        info, err := d.Info()
+        if err != nil {
+            return err
+        }
+        modTimes[name] = info.ModTime()
+
+        switch {
+        case strings.HasPrefix(name, partialsDir + "/"):
+            partials = append(partials, name)
+        case !strings.HasPrefix(name, layoutsDir + "/"):
+            pageNames = append(pageNames, name)
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    base := template.New("").Funcs(r.opts.Funcs)
+    if r.opts.Layout != "" {
+        if _, err := base.ParseFS(r.fsys, path.Join(layoutsDir, r.opts.Layout)); err != nil {
+            return fmt.Errorf("error while parsing layout: %w", err)
+        }
+    }
+    if len(partials) > 0 {
+        if _, err := base.ParseFS(r.fsys, partials...); err != nil {
+            return fmt.Errorf("error while parsing partials: %w", err)
+        }
+    }
+
+    pages := map[string]*template.Template{}
+    for _, name := range pageNames {
+        // Each page gets its own copy so their blocks don't clash
+        tmpl, err := base.Clone()
+        if err != nil {
+            return err
+        }
+        content, err := fs.ReadFile(r.fsys, name)
+        if err != nil {
+            return err
+        }
+        if _, err := tmpl.New(name).Parse(string(content)); err != nil {
+            return fmt.Errorf("error while parsing page: %w", err)
+        }
+        pages[name] = tmpl
+    }
+
+    r.mu.Lock()
+    r.pages = pages
+    r.modTimes = modTimes
+    r.mu.Unlock()
+    return nil
+}
+
+// changed reports whether any template was added, removed or modified
+// since the last load
+func (r *Renderer) changed() bool {
+    r.mu.RLock()
+    known := r.modTimes
+    r.mu.RUnlock()
+
+    seen := 0
+    changed := false
+    fs.WalkDir(r.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+        if err != nil || changed {
+            return fs.SkipAll
+        }
+        if d.IsDir() || path.Ext(name) != ".html" {
+            return nil
+        }
+        info, err := d.Info()
+        modTime, ok := known[name]
+        if err != nil || !ok || !info.ModTime().Equal(modTime) {
+            changed = true
+            return fs.SkipAll
+        }
+        seen++
+        return nil
+    })
+
+    return changed || seen != len(known)
+}
+
+// Execute writes page name, with or without its .html extension, to dst.
+func (r *Renderer) Execute(dst io.Writer, name string, data any) error {
+    if r.opts.Dev && r.changed() {
+        if err := r.load(); err != nil {
+            return err
+        }
+    }
+    if path.Ext(name) == "" {
+        name += ".html"
+    }
+
+    r.mu.RLock()
+    tmpl, ok := r.pages[name]
+    r.mu.RUnlock()
+    if !ok {
+        return fmt.Errorf("view %v not found", name)
+    }
+
+    if r.opts.Layout != "" {
+        return tmpl.ExecuteTemplate(dst, path.Base(r.opts.Layout), data)
+    }
+    return tmpl.ExecuteTemplate(dst, name, data)
+}
+
+// Render sends page name as a text/html response. Pages are rendered in
+// full before anything is written, so a failing template turns into a
+// 500 instead of half a page. 200 responses get an ETag and answer
+// conditional requests.
+func (r *Renderer) Render(w *response.Writer, req *request.Request, statusCode int, name string, data any) error {
+    body := &bytes.Buffer{}
+    if err := r.Execute(body, name, data); err != nil {
+        log.Println("view:", err)
+        w.WriteProblem(req, response.NewProblem(500, ""), nil)
+        return err
+    }
+
+    h := response.GetDefaultHeaders(0)
+    h.Replace("Content-Type", "text/html; charset=utf-8")
+    return w.WriteConditionalMessage(req, statusCode, h, body.Bytes())
+}
@@ -0,0 +1,83 @@
+package view
+
+import (
+    "bufio"
+    "bytes"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/aringq10/http-go-server/internal/request"
+    "github.com/aringq10/http-go-server/internal/response"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+    dir := t.TempDir()
+    for name, content := range files {
+        name = filepath.Join(dir, filepath.FromSlash(name))
+        require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+        require.NoError(t, os.WriteFile(name, []byte(content), 0644))
+    }
+    return dir
+}
+
+func render(t *testing.T, r *Renderer, name string, data any) (*http.Response, string) {
+    req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/html\r\n\r\n"))
+    require.NoError(t, err)
+
+    buf := &bytes.Buffer{}
+    r.Render(response.NewWriter(buf), req, 200, name, data)
+
+    resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+    require.NoError(t, err)
+    body, err := io.ReadAll(resp.Body)
+    require.NoError(t, err)
+    return resp, string(body)
+}
+
+func TestRenderer(t *testing.T) {
+    dir := writeTemplates(t, map[string]string{
+        "layouts/base.html": `<title>{{block "title" .}}untitled{{end}}</title>{{template "nav"}}{{block "content" .}}{{end}}`,
+        "partials/nav.html": `{{define "nav"}}<nav>home</nav>{{end}}`,
+        "index.html": `{{define "title"}}Home{{end}}{{define "content"}}<p>{{.}}</p>{{end}}`,
+        "admin/users.html": `{{define "content"}}<p>{{upper .}}</p>{{end}}`,
+    })
+    r, err := NewDir(dir, Options{Layout: "base.html", Funcs: map[string]any{"upper": strings.ToUpper}, Dev: true})
+    require.NoError(t, err)
+
+    // Test: Page inside the layout, data is escaped
+    resp, body := render(t, r, "index", "<b>banger</b>")
+    assert.Equal(t, 200, resp.StatusCode)
+    assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+    assert.NotEmpty(t, resp.Header.Get("ETag"))
+    assert.Equal(t, "<title>Home</title><nav>home</nav><p>&lt;b&gt;banger&lt;/b&gt;</p>", body)
+
+    // Test: Blocks of one page don't leak into another
+    _, body = render(t, r, "admin/users.html", "lane")
+    assert.Equal(t, "<title>untitled</title><nav>home</nav><p>LANE</p>", body)
+
+    // Test: Missing view is a 500
+    resp, _ = render(t, r, "nope", nil)
+    assert.Equal(t, 500, resp.StatusCode)
+
+    // Test: Dev mode picks up edits
+    index := filepath.Join(dir, "index.html")
+    require.NoError(t, os.WriteFile(index, []byte(`{{define "content"}}edited{{end}}`), 0644))
+    future := time.Now().Add(time.Minute)
+    require.NoError(t, os.Chtimes(index, future, future))
+    _, body = render(t, r, "index", nil)
+    assert.Equal(t, "<title>untitled</title><nav>home</nav>edited", body)
+
+    // Test: Without a layout pages render on their own
+    dir = writeTemplates(t, map[string]string{"plain.html": `hi {{.}}`})
+    r, err = New(os.DirFS(dir), Options{})
+    require.NoError(t, err)
+    _, body = render(t, r, "plain", "lane")
+    assert.Equal(t, "hi lane", body)
+}